@@ -0,0 +1,44 @@
+package hebbnet
+
+import (
+	"errors"
+
+	"github.com/unixpickle/autofunc"
+	"github.com/unixpickle/serializer"
+)
+
+// An Activation is a serializable activation function,
+// such as neuralnet.HyperbolicTangent, neuralnet.Sigmoid,
+// or neuralnet.ReLU.
+//
+// Custom activations may be used as long as they are
+// registered with the serializer package.
+type Activation interface {
+	autofunc.RFunc
+	serializer.Serializer
+}
+
+// serializeActivation serializes an activation with its
+// type, returning nil for a nil activation.
+func serializeActivation(a Activation) ([]byte, error) {
+	if a == nil {
+		return nil, nil
+	}
+	return serializer.SerializeWithType(a)
+}
+
+// deserializeActivation undoes serializeActivation.
+func deserializeActivation(data []byte) (Activation, error) {
+	if data == nil {
+		return nil, nil
+	}
+	obj, err := serializer.DeserializeWithType(data)
+	if err != nil {
+		return nil, err
+	}
+	act, ok := obj.(Activation)
+	if !ok {
+		return nil, errors.New("deserialized activation is not an Activation")
+	}
+	return act, nil
+}
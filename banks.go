@@ -0,0 +1,101 @@
+package hebbnet
+
+import (
+	"github.com/unixpickle/autofunc"
+	"github.com/unixpickle/num-analysis/linalg"
+)
+
+// A TraceBank is a Hebbian trace for every plastic
+// connection of a DenseLayer, along with the rates and
+// plasticities which determine how the trace is updated
+// and used.
+//
+// By giving a layer several banks with different rates,
+// every connection can keep both long-term and short-term
+// traces, rather than committing to a single timescale.
+type TraceBank struct {
+	// TraceRate is laid out like DenseLayer.TraceRate and
+	// uses the layer's RateGranularity.
+	TraceRate *autofunc.Variable
+
+	// Plasticities is laid out like the layer's
+	// Plasticities.
+	Plasticities *autofunc.Variable
+
+	// InitTrace is laid out like the layer's InitTrace.
+	InitTrace *autofunc.Variable
+}
+
+// AddTraceBank adds a bank to the layer and returns it.
+// The bank's rates are laid out like the layer's
+// TraceRate and initialized with r.
+// Its plasticities and initial trace are set to 0, except
+// that plasticities with sign constraints are set to a
+// small magnitude.
+//
+// The bank's trace is stored in the layer's state after
+// the traces of the existing banks, so adding a bank
+// changes the layout of the state.
+func (d *DenseLayer) AddTraceBank(r RateInitializer) *TraceBank {
+	res := &TraceBank{
+		TraceRate:    &autofunc.Variable{Vector: make(linalg.Vector, len(d.TraceRate.Vector))},
+		Plasticities: &autofunc.Variable{Vector: make(linalg.Vector, len(d.Plasticities.Vector))},
+		InitTrace:    &autofunc.Variable{Vector: make(linalg.Vector, len(d.InitTrace.Vector))},
+	}
+	d.initRates(res.TraceRate.Vector, r)
+	if d.InputSigns != nil {
+		res.Plasticities.Vector = unsoftplusSigned(res.Plasticities.Vector, d.plasticSigns())
+	}
+	d.Banks = append(d.Banks, res)
+	return res
+}
+
+// TraceBanks returns every trace bank of the layer.
+// The first bank is made up of the layer's TraceRate,
+// Plasticities, and InitTrace, and the rest come from
+// Banks.
+func (d *DenseLayer) TraceBanks() []*TraceBank {
+	res := []*TraceBank{{
+		TraceRate:    d.TraceRate,
+		Plasticities: d.Plasticities,
+		InitTrace:    d.InitTrace,
+	}}
+	return append(res, d.Banks...)
+}
+
+// traceSize returns the number of components in the
+// layer's state used by the traces of all the banks.
+func (d *DenseLayer) traceSize() int {
+	return len(d.InitTrace.Vector) * (len(d.Banks) + 1)
+}
+
+// plasticTrace computes the plastic part of the layer's
+// weights, which is the sum over all banks of the bank's
+// effective plasticities times its trace.
+// There is one value per plastic connection.
+func (d *DenseLayer) plasticTrace(traces []autofunc.Result) autofunc.Result {
+	var res autofunc.Result
+	for i, b := range d.TraceBanks() {
+		term := autofunc.Mul(d.bankPlasticities(b), traces[i])
+		if res == nil {
+			res = term
+		} else {
+			res = autofunc.Add(res, term)
+		}
+	}
+	return res
+}
+
+func (d *DenseLayer) plasticTraceR(rv autofunc.RVector,
+	traces []autofunc.RResult) autofunc.RResult {
+	var res autofunc.RResult
+	for i, b := range d.TraceBanks() {
+		term := autofunc.MulR(d.bankPlasticitiesR(rv, b), traces[i])
+		if res == nil {
+			res = term
+		} else {
+			res = autofunc.AddR(res, term)
+		}
+	}
+	return res
+}
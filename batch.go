@@ -0,0 +1,136 @@
+package hebbnet
+
+import (
+	"github.com/unixpickle/autofunc"
+	"github.com/unixpickle/num-analysis/linalg"
+	"github.com/unixpickle/weakai/rnn"
+)
+
+// applyBatched is like applyBlock, but it computes the
+// weight products for every sequence in the batch with a
+// single matrix-matrix product, and the Hebbian products
+// with one batched operation per trace bank.
+//
+// The per-sequence trace updates are still computed
+// separately for each sequence.
+func (d *DenseLayer) applyBatched(s []rnn.State, in []autofunc.Result) rnn.BlockResult {
+	res := &denseLayerOutput{}
+	res.StatePool, _ = rnn.PoolVecStates(s)
+	if len(in) == 0 {
+		return res
+	}
+
+	banks := d.TraceBanks()
+	bankTraces := make([][]autofunc.Result, len(banks))
+	for _, state := range res.StatePool {
+		traces, _ := d.splitState(state)
+		for i, trace := range traces {
+			bankTraces[i] = append(bankTraces[i], d.scatterPlastic(trace))
+		}
+	}
+	joinedIn := autofunc.Concat(in...)
+	res.Batch = autofunc.MatMulVecs(d.weights(), d.OutputCount, d.InputCount, joinedIn)
+	for i, b := range banks {
+		res.Batch = autofunc.Add(res.Batch,
+			newBatchHebbResult(d.scatterPlastic(d.bankPlasticities(b)),
+				autofunc.Concat(bankTraces[i]...), joinedIn, d.OutputCount, d.InputCount))
+	}
+	res.BatchPool = &autofunc.Variable{Vector: res.Batch.Output()}
+
+	traceRates := d.traceRates()
+	for i, input := range in {
+		out, hebbOut := d.activate(autofunc.Add(d.Biases,
+			autofunc.Slice(res.BatchPool, i*d.OutputCount, (i+1)*d.OutputCount)))
+		newState := d.updateTrace(res.StatePool[i], hebbOut, input, traceRates)
+		res.StateResults = append(res.StateResults, newState)
+		res.OutResults = append(res.OutResults, out)
+		res.StatesOut = append(res.StatesOut, rnn.VecState(newState.Output()))
+		res.VecsOut = append(res.VecsOut, out.Output())
+	}
+	return res
+}
+
+// batchHebbResult applies a different Hebbian matrix to
+// each vector in a batch.
+// For vector i, the result is (P o H_i)*x_i, where P is a
+// plasticity matrix and H_i is the i-th trace.
+type batchHebbResult struct {
+	Plasticities autofunc.Result
+	Traces       autofunc.Result
+	Vecs         autofunc.Result
+	Rows         int
+	Cols         int
+	Result       linalg.Vector
+}
+
+func newBatchHebbResult(plasticities, traces, vecs autofunc.Result,
+	rows, cols int) *batchHebbResult {
+	p := plasticities.Output()
+	h := traces.Output()
+	x := vecs.Output()
+	batchSize := len(x) / cols
+	res := make(linalg.Vector, batchSize*rows)
+	for i := 0; i < batchSize; i++ {
+		vec := x[i*cols : (i+1)*cols]
+		trace := h[i*rows*cols : (i+1)*rows*cols]
+		for row := 0; row < rows; row++ {
+			var sum float64
+			for col, xVal := range vec {
+				idx := row*cols + col
+				sum += p[idx] * trace[idx] * xVal
+			}
+			res[i*rows+row] = sum
+		}
+	}
+	return &batchHebbResult{
+		Plasticities: plasticities,
+		Traces:       traces,
+		Vecs:         vecs,
+		Rows:         rows,
+		Cols:         cols,
+		Result:       res,
+	}
+}
+
+func (b *batchHebbResult) Output() linalg.Vector {
+	return b.Result
+}
+
+func (b *batchHebbResult) Constant(g autofunc.Gradient) bool {
+	return b.Plasticities.Constant(g) && b.Traces.Constant(g) && b.Vecs.Constant(g)
+}
+
+func (b *batchHebbResult) PropagateGradient(upstream linalg.Vector, g autofunc.Gradient) {
+	p := b.Plasticities.Output()
+	h := b.Traces.Output()
+	x := b.Vecs.Output()
+	batchSize := len(x) / b.Cols
+
+	pGrad := make(linalg.Vector, len(p))
+	hGrad := make(linalg.Vector, len(h))
+	xGrad := make(linalg.Vector, len(x))
+	for i := 0; i < batchSize; i++ {
+		vec := x[i*b.Cols : (i+1)*b.Cols]
+		vecGrad := xGrad[i*b.Cols : (i+1)*b.Cols]
+		trace := h[i*b.Rows*b.Cols : (i+1)*b.Rows*b.Cols]
+		traceGrad := hGrad[i*b.Rows*b.Cols : (i+1)*b.Rows*b.Cols]
+		for row, u := range upstream[i*b.Rows : (i+1)*b.Rows] {
+			for col, xVal := range vec {
+				idx := row*b.Cols + col
+				pGrad[idx] += u * trace[idx] * xVal
+				traceGrad[idx] = u * p[idx] * xVal
+				vecGrad[col] += u * p[idx] * trace[idx]
+			}
+		}
+	}
+
+	if !b.Plasticities.Constant(g) {
+		b.Plasticities.PropagateGradient(pGrad, g)
+	}
+	if !b.Traces.Constant(g) {
+		b.Traces.PropagateGradient(hGrad, g)
+	}
+	if !b.Vecs.Constant(g) {
+		b.Vecs.PropagateGradient(xGrad, g)
+	}
+}
@@ -0,0 +1,91 @@
+package hebbnet
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/unixpickle/autofunc"
+	"github.com/unixpickle/num-analysis/linalg"
+	"github.com/unixpickle/weakai/rnn"
+)
+
+func TestDenseBatchedMatches(t *testing.T) {
+	layer := NewDenseLayer(5, 3, true)
+	layer.UseActivation = true
+	for _, p := range layer.Parameters() {
+		for i := range p.Vector {
+			p.Vector[i] = rand.NormFloat64()
+		}
+	}
+	states, inputs := randomBatch(layer, 4)
+	for step := 0; step < 3; step++ {
+		expected := applyBlock(states, inputs, layer.timestep)
+		actual := layer.ApplyBlock(states, inputs)
+		for i, exp := range expected.Outputs() {
+			act := actual.Outputs()[i]
+			if exp.Copy().Scale(-1).Add(act).MaxAbs() > 1e-8 {
+				t.Errorf("step %d seq %d: expected output %v but got %v", step, i, exp, act)
+			}
+			expState := linalg.Vector(expected.States()[i].(rnn.VecState))
+			actState := linalg.Vector(actual.States()[i].(rnn.VecState))
+			if expState.Copy().Scale(-1).Add(actState).MaxAbs() > 1e-8 {
+				t.Errorf("step %d seq %d: states differ", step, i)
+			}
+		}
+		states = actual.States()
+	}
+}
+
+func BenchmarkDenseBatched(b *testing.B) {
+	benchmarkDense(b, func(l *DenseLayer, s []rnn.State, in []autofunc.Result) rnn.BlockResult {
+		return l.ApplyBlock(s, in)
+	})
+}
+
+func BenchmarkDenseSequential(b *testing.B) {
+	benchmarkDense(b, func(l *DenseLayer, s []rnn.State, in []autofunc.Result) rnn.BlockResult {
+		return applyBlock(s, in, l.timestep)
+	})
+}
+
+func benchmarkDense(b *testing.B, f func(l *DenseLayer, s []rnn.State,
+	in []autofunc.Result) rnn.BlockResult) {
+	for _, batchSize := range []int{1, 4, 16, 64} {
+		b.Run(fmt.Sprintf("Batch%d", batchSize), func(b *testing.B) {
+			layer := NewDenseLayer(40, 40, true)
+			layer.UseActivation = true
+			states, inputs := randomBatch(layer, batchSize)
+			upstream := make([]linalg.Vector, batchSize)
+			for i := range upstream {
+				upstream[i] = make(linalg.Vector, layer.OutputCount)
+				upstream[i][0] = 1
+			}
+			grad := autofunc.NewGradient(layer.Parameters())
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				res := f(layer, states, inputs)
+				res.PropagateGradient(upstream, nil, grad)
+			}
+		})
+	}
+}
+
+func randomBatch(l *DenseLayer, batchSize int) ([]rnn.State, []autofunc.Result) {
+	var states []rnn.State
+	var inputs []autofunc.Result
+	for i := 0; i < batchSize; i++ {
+		state := make(rnn.VecState, len(l.InitTrace.Vector))
+		for j := range state {
+			state[j] = rand.NormFloat64()
+		}
+		input := &autofunc.Variable{Vector: make(linalg.Vector, l.InputCount)}
+		for j := range input.Vector {
+			input.Vector[j] = math.Tanh(rand.NormFloat64())
+		}
+		states = append(states, state)
+		inputs = append(inputs, input)
+	}
+	return states, inputs
+}
@@ -0,0 +1,174 @@
+package hebbnet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/unixpickle/autofunc"
+	"github.com/unixpickle/num-analysis/linalg"
+)
+
+// An Encoding determines how a DenseLayer is serialized.
+type Encoding int
+
+const (
+	// JSONEncoding stores the entire layer as JSON.
+	JSONEncoding Encoding = iota
+
+	// Float64Encoding stores the layer's parameters as
+	// little-endian float64 values.
+	// It is lossless.
+	Float64Encoding
+
+	// Float32Encoding stores the layer's parameters as
+	// little-endian float32 values.
+	// It is half the size of Float64Encoding, but the
+	// parameters lose precision.
+	Float32Encoding
+)
+
+// binaryMagic begins every binary-encoded DenseLayer.
+var binaryMagic = []byte("HBDL")
+
+// isBinaryDenseLayer checks if data is a binary-encoded
+// DenseLayer.
+func isBinaryDenseLayer(data []byte) bool {
+	return bytes.HasPrefix(data, binaryMagic)
+}
+
+// binaryVars returns pointers to every parameter vector
+// of the layer, in the order they are stored in the
+// binary encoding.
+// Adding vectors to this list would make older encodings
+// unreadable, so small parameters added since, such as
+// TimingCoeffs, are kept in the JSON header instead.
+func (d *DenseLayer) binaryVars() []**autofunc.Variable {
+	res := []**autofunc.Variable{
+		&d.TraceRate,
+		&d.Weights,
+		&d.Biases,
+		&d.Plasticities,
+		&d.InitTrace,
+		&d.DecayRate,
+		&d.Thresholds,
+		&d.ThresholdRate,
+	}
+	for _, b := range d.Banks {
+		res = append(res, &b.TraceRate, &b.Plasticities, &b.InitTrace)
+	}
+	return res
+}
+
+// encodeBinary encodes the layer in a binary format.
+//
+// The encoding starts with binaryMagic and the size of
+// each float in bytes.
+// Next is the length of a JSON header, followed by the
+// header itself, which is the JSON encoding of the layer
+// without its parameter vectors.
+// Finally, the parameter vectors are stored in the order
+// given by binaryVars, each preceded by its length (or -1
+// if the vector is nil).
+func (d *DenseLayer) encodeBinary() ([]byte, error) {
+	floatSize := 8
+	if d.Encoding == Float32Encoding {
+		floatSize = 4
+	} else if d.Encoding != Float64Encoding {
+		return nil, fmt.Errorf("unknown DenseLayer encoding: %d", d.Encoding)
+	}
+
+	stripped := *d
+	stripped.Banks = make([]*TraceBank, len(d.Banks))
+	for i := range stripped.Banks {
+		stripped.Banks[i] = &TraceBank{}
+	}
+	for _, v := range stripped.binaryVars() {
+		*v = nil
+	}
+	header, err := stripped.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.Write(binaryMagic)
+	buf.WriteByte(byte(floatSize))
+	binary.Write(&buf, binary.LittleEndian, uint32(len(header)))
+	buf.Write(header)
+	for _, v := range d.binaryVars() {
+		if *v == nil {
+			binary.Write(&buf, binary.LittleEndian, int32(-1))
+			continue
+		}
+		vec := (*v).Vector
+		binary.Write(&buf, binary.LittleEndian, int32(len(vec)))
+		chunk := make([]byte, floatSize*len(vec))
+		for i, x := range vec {
+			if floatSize == 4 {
+				binary.LittleEndian.PutUint32(chunk[i*4:], math.Float32bits(float32(x)))
+			} else {
+				binary.LittleEndian.PutUint64(chunk[i*8:], math.Float64bits(x))
+			}
+		}
+		buf.Write(chunk)
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeBinary decodes a layer produced by encodeBinary.
+// Like UnmarshalJSON, it migrates and validates the layer.
+func (d *DenseLayer) decodeBinary(data []byte) error {
+	r := bytes.NewReader(data[len(binaryMagic):])
+	floatSize, err := r.ReadByte()
+	if err != nil {
+		return errTruncatedBinary
+	} else if floatSize != 4 && floatSize != 8 {
+		return fmt.Errorf("invalid float size in binary DenseLayer: %d", floatSize)
+	}
+
+	var headerLen uint32
+	if err := binary.Read(r, binary.LittleEndian, &headerLen); err != nil {
+		return errTruncatedBinary
+	} else if int64(headerLen) > int64(r.Len()) {
+		return errTruncatedBinary
+	}
+	header := make([]byte, headerLen)
+	r.Read(header)
+	obj, err := d.decodeJSON(header)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range d.binaryVars() {
+		var length int32
+		if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+			return errTruncatedBinary
+		}
+		if length < 0 {
+			*v = nil
+			continue
+		} else if int64(length)*int64(floatSize) > int64(r.Len()) {
+			return errTruncatedBinary
+		}
+		chunk := make([]byte, int(length)*int(floatSize))
+		r.Read(chunk)
+		vec := make(linalg.Vector, length)
+		for i := range vec {
+			if floatSize == 4 {
+				vec[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(chunk[i*4:])))
+			} else {
+				vec[i] = math.Float64frombits(binary.LittleEndian.Uint64(chunk[i*8:]))
+			}
+		}
+		*v = &autofunc.Variable{Vector: vec}
+	}
+	if r.Len() != 0 {
+		return errors.New("unexpected trailing data in binary DenseLayer")
+	}
+	return d.finishDecode(obj)
+}
+
+var errTruncatedBinary = errors.New("truncated binary DenseLayer")
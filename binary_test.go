@@ -0,0 +1,94 @@
+package hebbnet
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/unixpickle/serializer"
+)
+
+func TestDenseBinaryRoundTrip(t *testing.T) {
+	layer := NewDenseLayerRates(5, 3, PerRowColumnRates)
+	layer.SetPlasticMask(RandomMask(5, 3, 0.5))
+	layer.UseCovarianceRule(true)
+	layer.UntieDecay()
+	layer.AddTraceBank(&HalfLifeRates{HalfLives: []float64{10}})
+	layer.TraceClip = 0.7
+	for _, p := range layer.Parameters() {
+		for i := range p.Vector {
+			p.Vector[i] = rand.NormFloat64()
+		}
+	}
+	layer.Encoding = Float64Encoding
+
+	data, err := serializer.SerializeWithType(layer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := serializer.DeserializeWithType(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := obj.(*DenseLayer)
+	if decoded.Encoding != Float64Encoding || decoded.Rule != CovarianceRule ||
+		decoded.TraceClip != 0.7 || len(decoded.PlasticIndices) != len(layer.PlasticIndices) {
+		t.Error("layer settings were not preserved")
+	}
+	expected := layer.Parameters()
+	actual := decoded.Parameters()
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %d parameters but got %d", len(expected), len(actual))
+	}
+	for i, p := range expected {
+		checkVec(t, "parameter", actual[i].Vector, p.Vector)
+	}
+}
+
+func TestDenseBinaryFloat32(t *testing.T) {
+	layer := NewDenseLayer(40, 40, true)
+	for _, p := range layer.Parameters() {
+		for i := range p.Vector {
+			p.Vector[i] = rand.NormFloat64()
+		}
+	}
+	jsonData, err := layer.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	layer.Encoding = Float32Encoding
+	data, err := layer.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) >= len(jsonData)/2 {
+		t.Errorf("binary encoding is too large: %d bytes (JSON is %d)", len(data),
+			len(jsonData))
+	}
+	decoded, err := DeserializeDenseLayer(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Encoding != Float32Encoding {
+		t.Error("encoding was not preserved")
+	}
+	for i, x := range layer.Weights.Vector {
+		if math.Abs(decoded.Weights.Vector[i]-x) > 1e-5*math.Max(1, math.Abs(x)) {
+			t.Fatalf("weight %d: expected %f but got %f", i, x, decoded.Weights.Vector[i])
+		}
+	}
+}
+
+func TestDenseBinaryCorrupt(t *testing.T) {
+	layer := NewDenseLayer(3, 2, false)
+	layer.Encoding = Float64Encoding
+	data, err := layer.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []int{5, 20, len(data) - 1} {
+		if _, err := DeserializeDenseLayer(data[:n]); err == nil {
+			t.Errorf("expected error for %d bytes", n)
+		}
+	}
+}
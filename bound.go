@@ -0,0 +1,252 @@
+package hebbnet
+
+import (
+	"math"
+
+	"github.com/unixpickle/autofunc"
+	"github.com/unixpickle/num-analysis/linalg"
+)
+
+// clipTrace clips every component of a vector to the
+// range [-max, max].
+func clipTrace(in autofunc.Result, max float64) autofunc.Result {
+	return &clipResult{
+		Input:  in,
+		Max:    max,
+		Result: clipVec(in.Output(), max),
+	}
+}
+
+func clipTraceR(in autofunc.RResult, max float64) autofunc.RResult {
+	res := &clipRResult{
+		Input:   in,
+		Max:     max,
+		Result:  clipVec(in.Output(), max),
+		RResult: make(linalg.Vector, len(in.Output())),
+	}
+	for i, x := range in.ROutput() {
+		if math.Abs(in.Output()[i]) < max {
+			res.RResult[i] = x
+		}
+	}
+	return res
+}
+
+type clipResult struct {
+	Input  autofunc.Result
+	Max    float64
+	Result linalg.Vector
+}
+
+func (c *clipResult) Output() linalg.Vector {
+	return c.Result
+}
+
+func (c *clipResult) Constant(g autofunc.Gradient) bool {
+	return c.Input.Constant(g)
+}
+
+func (c *clipResult) PropagateGradient(upstream linalg.Vector, g autofunc.Gradient) {
+	if c.Input.Constant(g) {
+		return
+	}
+	maskClipped(upstream, c.Input.Output(), c.Max)
+	c.Input.PropagateGradient(upstream, g)
+}
+
+type clipRResult struct {
+	Input   autofunc.RResult
+	Max     float64
+	Result  linalg.Vector
+	RResult linalg.Vector
+}
+
+func (c *clipRResult) Output() linalg.Vector {
+	return c.Result
+}
+
+func (c *clipRResult) ROutput() linalg.Vector {
+	return c.RResult
+}
+
+func (c *clipRResult) Constant(rg autofunc.RGradient, g autofunc.Gradient) bool {
+	return c.Input.Constant(rg, g)
+}
+
+func (c *clipRResult) PropagateRGradient(upstream, upstreamR linalg.Vector,
+	rg autofunc.RGradient, g autofunc.Gradient) {
+	if c.Input.Constant(rg, g) {
+		return
+	}
+	maskClipped(upstream, c.Input.Output(), c.Max)
+	maskClipped(upstreamR, c.Input.Output(), c.Max)
+	c.Input.PropagateRGradient(upstream, upstreamR, rg, g)
+}
+
+func clipVec(v linalg.Vector, max float64) linalg.Vector {
+	res := make(linalg.Vector, len(v))
+	for i, x := range v {
+		res[i] = math.Max(-max, math.Min(max, x))
+	}
+	return res
+}
+
+func maskClipped(upstream, in linalg.Vector, max float64) {
+	for i, x := range in {
+		if math.Abs(x) >= max {
+			upstream[i] = 0
+		}
+	}
+}
+
+// boundRowNorms rescales the rows of a row-major matrix
+// so that no row has an L2 norm greater than max.
+func boundRowNorms(in autofunc.Result, cols int, max float64) autofunc.Result {
+	norms := rowNorms(in.Output(), cols)
+	return &rowNormResult{
+		Input:  in,
+		Cols:   cols,
+		Max:    max,
+		Norms:  norms,
+		Result: scaleRows(in.Output(), cols, norms, max),
+	}
+}
+
+func boundRowNormsR(in autofunc.RResult, cols int, max float64) autofunc.RResult {
+	inVec := in.Output()
+	inR := in.ROutput()
+	norms := rowNorms(inVec, cols)
+	res := &rowNormRResult{
+		Input:   in,
+		Cols:    cols,
+		Max:     max,
+		Norms:   norms,
+		Result:  scaleRows(inVec, cols, norms, max),
+		RResult: make(linalg.Vector, len(inVec)),
+	}
+	for row, norm := range res.Norms {
+		rowVec := inVec[row*cols : (row+1)*cols]
+		rowR := inR[row*cols : (row+1)*cols]
+		outR := res.RResult[row*cols : (row+1)*cols]
+		if norm <= max {
+			copy(outR, rowR)
+			continue
+		}
+		scale := max / norm
+		dot := rowVec.Dot(rowR) / (norm * norm)
+		for i, x := range rowR {
+			outR[i] = scale * (x - dot*rowVec[i])
+		}
+	}
+	return res
+}
+
+type rowNormResult struct {
+	Input  autofunc.Result
+	Cols   int
+	Max    float64
+	Norms  []float64
+	Result linalg.Vector
+}
+
+func (r *rowNormResult) Output() linalg.Vector {
+	return r.Result
+}
+
+func (r *rowNormResult) Constant(g autofunc.Gradient) bool {
+	return r.Input.Constant(g)
+}
+
+func (r *rowNormResult) PropagateGradient(upstream linalg.Vector, g autofunc.Gradient) {
+	if r.Input.Constant(g) {
+		return
+	}
+	inVec := r.Input.Output()
+	for row, norm := range r.Norms {
+		if norm <= r.Max {
+			continue
+		}
+		rowVec := inVec[row*r.Cols : (row+1)*r.Cols]
+		rowUp := upstream[row*r.Cols : (row+1)*r.Cols]
+		scale := r.Max / norm
+		dot := rowVec.Dot(rowUp) / (norm * norm)
+		for i, x := range rowVec {
+			rowUp[i] = scale * (rowUp[i] - dot*x)
+		}
+	}
+	r.Input.PropagateGradient(upstream, g)
+}
+
+type rowNormRResult struct {
+	Input   autofunc.RResult
+	Cols    int
+	Max     float64
+	Norms   []float64
+	Result  linalg.Vector
+	RResult linalg.Vector
+}
+
+func (r *rowNormRResult) Output() linalg.Vector {
+	return r.Result
+}
+
+func (r *rowNormRResult) ROutput() linalg.Vector {
+	return r.RResult
+}
+
+func (r *rowNormRResult) Constant(rg autofunc.RGradient, g autofunc.Gradient) bool {
+	return r.Input.Constant(rg, g)
+}
+
+func (r *rowNormRResult) PropagateRGradient(upstream, upstreamR linalg.Vector,
+	rg autofunc.RGradient, g autofunc.Gradient) {
+	if r.Input.Constant(rg, g) {
+		return
+	}
+	inVec := r.Input.Output()
+	inR := r.Input.ROutput()
+	for row, norm := range r.Norms {
+		if norm <= r.Max {
+			continue
+		}
+		rowVec := inVec[row*r.Cols : (row+1)*r.Cols]
+		rowR := inR[row*r.Cols : (row+1)*r.Cols]
+		rowUp := upstream[row*r.Cols : (row+1)*r.Cols]
+		rowUpR := upstreamR[row*r.Cols : (row+1)*r.Cols]
+
+		// With a = max/norm and b = (row . up)/norm^2, the
+		// gradient is a*(up - b*row), and its R-derivative
+		// follows from the product rule.
+		normSq := norm * norm
+		a := r.Max / norm
+		b := rowVec.Dot(rowUp) / normSq
+		rowDotR := rowVec.Dot(rowR)
+		aR := -a * rowDotR / normSq
+		bR := (rowR.Dot(rowUp)+rowVec.Dot(rowUpR))/normSq - 2*b*rowDotR/normSq
+		for i, x := range rowVec {
+			up, upR := rowUp[i], rowUpR[i]
+			rowUp[i] = a * (up - b*x)
+			rowUpR[i] = aR*(up-b*x) + a*(upR-bR*x-b*rowR[i])
+		}
+	}
+	r.Input.PropagateRGradient(upstream, upstreamR, rg, g)
+}
+
+func scaleRows(v linalg.Vector, cols int, norms []float64, max float64) linalg.Vector {
+	res := make(linalg.Vector, len(v))
+	copy(res, v)
+	for row, norm := range norms {
+		if norm > max {
+			res[row*cols : (row+1)*cols].Scale(max / norm)
+		}
+	}
+	return res
+}
+
+func rowNorms(v linalg.Vector, cols int) []float64 {
+	res := make([]float64, len(v)/cols)
+	for i := range res {
+		res[i] = v[i*cols : (i+1)*cols].Mag()
+	}
+	return res
+}
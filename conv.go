@@ -0,0 +1,268 @@
+package hebbnet
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+
+	"github.com/unixpickle/autofunc"
+	"github.com/unixpickle/num-analysis/linalg"
+	"github.com/unixpickle/serializer"
+	"github.com/unixpickle/weakai/neuralnet"
+	"github.com/unixpickle/weakai/rnn"
+)
+
+func init() {
+	var c ConvHebbLayer
+	serializer.RegisterTypedDeserializer(c.SerializerType(), DeserializeConvHebbLayer)
+}
+
+// A ConvHebbLayer is a convolutional recurrent layer with
+// Hebbian plasticities as well as standard weights.
+//
+// Inputs and outputs are images stored in row-major order,
+// with the components of each pixel stored contiguously.
+// The convolution does not use padding.
+//
+// Every entry of every kernel has a fixed weight and a
+// plasticity.
+// The Hebbian trace has one entry per kernel entry, and it
+// is shared across all spatial positions: at each step,
+// the trace is updated using the average of the outer
+// products between every output pixel and the input patch
+// that produced it.
+type ConvHebbLayer struct {
+	InputWidth  int
+	InputHeight int
+	InputDepth  int
+
+	FilterWidth  int
+	FilterHeight int
+	FilterCount  int
+	Stride       int
+
+	// TraceRate stores either one rate or one rate per
+	// kernel entry, and is used like DenseLayer.TraceRate.
+	TraceRate *autofunc.Variable
+
+	// Weights stores the kernels one after another.
+	// Each kernel is stored in the same order as the input
+	// patches it is applied to.
+	Weights *autofunc.Variable
+
+	// Biases stores one bias per filter.
+	Biases *autofunc.Variable
+
+	// Plasticities is laid out like Weights.
+	Plasticities *autofunc.Variable
+
+	// InitTrace is the initial value for the Hebbian trace.
+	InitTrace *autofunc.Variable
+
+	// If UseActivation is true, then outputs of the layer
+	// are fed into hyperbolic tangent and the tangents
+	// are used to compute the Hebbian trace.
+	UseActivation bool
+}
+
+// DeserializeConvHebbLayer deserializes a ConvHebbLayer.
+func DeserializeConvHebbLayer(d []byte) (*ConvHebbLayer, error) {
+	var res ConvHebbLayer
+	if err := json.Unmarshal(d, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// NewConvHebbLayer creates a ConvHebbLayer with
+// pre-initialized (semi-randomized) parameters.
+// If variableRate is true, a different trace rate is used
+// for each kernel entry.
+func NewConvHebbLayer(inWidth, inHeight, inDepth, filterWidth, filterHeight,
+	filterCount, stride int, variableRate bool) *ConvHebbLayer {
+	kernelSize := filterWidth * filterHeight * inDepth
+	weightCount := kernelSize * filterCount
+	traceCount := 1
+	if variableRate {
+		traceCount = weightCount
+	}
+	res := &ConvHebbLayer{
+		InputWidth:   inWidth,
+		InputHeight:  inHeight,
+		InputDepth:   inDepth,
+		FilterWidth:  filterWidth,
+		FilterHeight: filterHeight,
+		FilterCount:  filterCount,
+		Stride:       stride,
+		TraceRate:    &autofunc.Variable{Vector: make(linalg.Vector, traceCount)},
+		Weights:      &autofunc.Variable{Vector: make(linalg.Vector, weightCount)},
+		Biases:       &autofunc.Variable{Vector: make(linalg.Vector, filterCount)},
+		Plasticities: &autofunc.Variable{Vector: make(linalg.Vector, weightCount)},
+		InitTrace:    &autofunc.Variable{Vector: make(linalg.Vector, weightCount)},
+	}
+	weightStddev := 1 / math.Sqrt(float64(kernelSize))
+	for i := range res.Weights.Vector {
+		res.Weights.Vector[i] = rand.NormFloat64() * weightStddev
+	}
+	return res
+}
+
+// OutputWidth returns the width of the output images.
+func (c *ConvHebbLayer) OutputWidth() int {
+	return (c.InputWidth-c.FilterWidth)/c.Stride + 1
+}
+
+// OutputHeight returns the height of the output images.
+func (c *ConvHebbLayer) OutputHeight() int {
+	return (c.InputHeight-c.FilterHeight)/c.Stride + 1
+}
+
+// Parameters returns the layer's learnable parameters.
+func (c *ConvHebbLayer) Parameters() []*autofunc.Variable {
+	return []*autofunc.Variable{
+		c.TraceRate,
+		c.Weights,
+		c.Biases,
+		c.Plasticities,
+		c.InitTrace,
+	}
+}
+
+// StartState returns the initial trace.
+func (c *ConvHebbLayer) StartState() rnn.State {
+	return rnn.VecState(c.InitTrace.Vector)
+}
+
+// StartRState returns the initial trace.
+func (c *ConvHebbLayer) StartRState(rv autofunc.RVector) rnn.RState {
+	rvar := autofunc.NewRVariable(c.InitTrace, rv)
+	return rnn.VecRState{State: rvar.Output(), RState: rvar.ROutput()}
+}
+
+// PropagateStart propagates through the start state.
+func (c *ConvHebbLayer) PropagateStart(_ []rnn.State, s []rnn.StateGrad, g autofunc.Gradient) {
+	rnn.PropagateVarState(c.InitTrace, s, g)
+}
+
+// PropagateStartR propagates through the start state.
+func (c *ConvHebbLayer) PropagateStartR(_ []rnn.RState, s []rnn.RStateGrad,
+	rg autofunc.RGradient, g autofunc.Gradient) {
+	rnn.PropagateVarStateR(c.InitTrace, s, rg, g)
+}
+
+// ApplyBlock applies the layer to a batch of inputs.
+func (c *ConvHebbLayer) ApplyBlock(s []rnn.State, in []autofunc.Result) rnn.BlockResult {
+	return applyBlock(s, in, c.timestep)
+}
+
+// ApplyBlockR applies the layer to a batch of inputs.
+func (c *ConvHebbLayer) ApplyBlockR(rv autofunc.RVector, s []rnn.RState,
+	in []autofunc.RResult) rnn.BlockRResult {
+	return applyBlockR(s, in, func(state, in autofunc.RResult) (newState, out autofunc.RResult) {
+		return c.timestepR(rv, state, in)
+	})
+}
+
+// SerializerType returns the unique ID used to serialize
+// this type with the serializer package.
+func (c *ConvHebbLayer) SerializerType() string {
+	return "github.com/unixpickle/hebbnet.ConvHebbLayer"
+}
+
+// Serialize serializes the layer.
+func (c *ConvHebbLayer) Serialize() ([]byte, error) {
+	return json.Marshal(c)
+}
+
+func (c *ConvHebbLayer) timestep(state, in autofunc.Result) (newState, out autofunc.Result) {
+	kernelSize := c.kernelSize()
+	patchCount := c.OutputWidth() * c.OutputHeight()
+	patches := gather(in, c.patchIndices())
+	kernels := autofunc.Add(c.Weights, autofunc.Mul(c.Plasticities, state))
+
+	var outPixels []autofunc.Result
+	var hebbSum autofunc.Result
+	for i := 0; i < patchCount; i++ {
+		patch := autofunc.Slice(patches, i*kernelSize, (i+1)*kernelSize)
+		pixel := autofunc.Add(c.Biases, autofunc.MatMulVec(kernels, c.FilterCount,
+			kernelSize, patch))
+		if c.UseActivation {
+			pixel = neuralnet.HyperbolicTangent{}.Apply(pixel)
+		}
+		outPixels = append(outPixels, pixel)
+		hebb := autofunc.OuterProduct(pixel, patch)
+		if hebbSum == nil {
+			hebbSum = hebb
+		} else {
+			hebbSum = autofunc.Add(hebbSum, hebb)
+		}
+	}
+	out = autofunc.Concat(outPixels...)
+
+	traceRate := neuralnet.Sigmoid{}.Apply(c.TraceRate)
+	keepRate := autofunc.AddScaler(autofunc.Scale(traceRate, -1), 1)
+	hebbMean := autofunc.Scale(hebbSum, 1/float64(patchCount))
+	newState = autofunc.Add(scaleByRate(state, keepRate), scaleByRate(hebbMean, traceRate))
+	return
+}
+
+func (c *ConvHebbLayer) timestepR(rv autofunc.RVector, state,
+	in autofunc.RResult) (newState, out autofunc.RResult) {
+	kernelSize := c.kernelSize()
+	patchCount := c.OutputWidth() * c.OutputHeight()
+	patches := gatherR(in, c.patchIndices())
+	kernels := autofunc.AddR(autofunc.NewRVariable(c.Weights, rv),
+		autofunc.MulR(autofunc.NewRVariable(c.Plasticities, rv), state))
+	biases := autofunc.NewRVariable(c.Biases, rv)
+
+	var outPixels []autofunc.RResult
+	var hebbSum autofunc.RResult
+	for i := 0; i < patchCount; i++ {
+		patch := autofunc.SliceR(patches, i*kernelSize, (i+1)*kernelSize)
+		pixel := autofunc.AddR(biases, autofunc.MatMulVecR(kernels, c.FilterCount,
+			kernelSize, patch))
+		if c.UseActivation {
+			pixel = neuralnet.HyperbolicTangent{}.ApplyR(rv, pixel)
+		}
+		outPixels = append(outPixels, pixel)
+		hebb := autofunc.OuterProductR(pixel, patch)
+		if hebbSum == nil {
+			hebbSum = hebb
+		} else {
+			hebbSum = autofunc.AddR(hebbSum, hebb)
+		}
+	}
+	out = autofunc.ConcatR(outPixels...)
+
+	traceRate := neuralnet.Sigmoid{}.ApplyR(rv, autofunc.NewRVariable(c.TraceRate, rv))
+	keepRate := autofunc.AddScalerR(autofunc.ScaleR(traceRate, -1), 1)
+	hebbMean := autofunc.ScaleR(hebbSum, 1/float64(patchCount))
+	newState = autofunc.AddR(scaleByRateR(state, keepRate), scaleByRateR(hebbMean, traceRate))
+	return
+}
+
+func (c *ConvHebbLayer) kernelSize() int {
+	return c.FilterWidth * c.FilterHeight * c.InputDepth
+}
+
+// patchIndices computes, for every output pixel, the
+// indices of the input components in the corresponding
+// input patch.
+func (c *ConvHebbLayer) patchIndices() []int {
+	var res []int
+	for y := 0; y < c.OutputHeight(); y++ {
+		for x := 0; x < c.OutputWidth(); x++ {
+			for fy := 0; fy < c.FilterHeight; fy++ {
+				for fx := 0; fx < c.FilterWidth; fx++ {
+					inY := y*c.Stride + fy
+					inX := x*c.Stride + fx
+					start := (inY*c.InputWidth + inX) * c.InputDepth
+					for z := 0; z < c.InputDepth; z++ {
+						res = append(res, start+z)
+					}
+				}
+			}
+		}
+	}
+	return res
+}
@@ -0,0 +1,23 @@
+package hebbnet
+
+import "testing"
+
+func TestConvHebb(t *testing.T) {
+	block := NewConvHebbLayer(2, 2, 1, 2, 1, 2, 1, true)
+	block.UseActivation = true
+	checkBlock(t, block)
+}
+
+func TestConvHebbPatchIndices(t *testing.T) {
+	layer := NewConvHebbLayer(3, 2, 2, 2, 2, 1, 1, false)
+	expected := []int{0, 1, 2, 3, 6, 7, 8, 9, 2, 3, 4, 5, 8, 9, 10, 11}
+	actual := layer.patchIndices()
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %v but got %v", expected, actual)
+	}
+	for i, x := range expected {
+		if actual[i] != x {
+			t.Fatalf("expected %v but got %v", expected, actual)
+		}
+	}
+}
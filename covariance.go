@@ -0,0 +1,173 @@
+package hebbnet
+
+import (
+	"github.com/unixpickle/autofunc"
+	"github.com/unixpickle/num-analysis/linalg"
+	"github.com/unixpickle/weakai/neuralnet"
+)
+
+// UseCovarianceRule switches the layer to CovarianceRule
+// and creates its thresholds, which are initialized to 0.
+//
+// If running is true, the thresholds are running averages
+// of the outputs and inputs, and they are stored in the
+// layer's state.
+// Otherwise, they are learned constants.
+func (d *DenseLayer) UseCovarianceRule(running bool) {
+	d.Rule = CovarianceRule
+	d.Thresholds = &autofunc.Variable{
+		Vector: make(linalg.Vector, d.OutputCount+d.InputCount),
+	}
+	d.ThresholdRate = nil
+	if running {
+		d.ThresholdRate = &autofunc.Variable{Vector: []float64{logit(0.1)}}
+	}
+}
+
+// runningThresholds returns true if the layer's state
+// includes running thresholds.
+func (d *DenseLayer) runningThresholds() bool {
+	return d.Rule == CovarianceRule && d.ThresholdRate != nil
+}
+
+// startVars returns the variables which make up the
+// initial state of the layer, in order.
+func (d *DenseLayer) startVars() []*autofunc.Variable {
+	var res []*autofunc.Variable
+	for _, b := range d.TraceBanks() {
+		res = append(res, b.InitTrace)
+	}
+	if d.runningThresholds() {
+		res = append(res, d.Thresholds)
+	} else if d.Rule == STDPRule {
+		res = append(res, d.initHistory())
+	}
+	return res
+}
+
+// stateSize returns the number of components in the
+// layer's state.
+func (d *DenseLayer) stateSize() int {
+	var res int
+	for _, v := range d.startVars() {
+		res += len(v.Vector)
+	}
+	return res
+}
+
+// hasRuleState returns true if the layer's plasticity
+// rule stores extra state after the Hebbian traces.
+func (d *DenseLayer) hasRuleState() bool {
+	return d.runningThresholds() || d.Rule == STDPRule
+}
+
+// splitState splits a state into the Hebbian trace of
+// every bank and the rule state.
+// The rule state contains the running thresholds for
+// CovarianceRule, or the previous output and input for
+// STDPRule.
+// If the layer has no rule state, the second return value
+// is nil.
+func (d *DenseLayer) splitState(state autofunc.Result) (traces []autofunc.Result,
+	ruleState autofunc.Result) {
+	if len(d.Banks) == 0 && !d.hasRuleState() {
+		return []autofunc.Result{state}, nil
+	}
+	traceSize := len(d.InitTrace.Vector)
+	for i := 0; i <= len(d.Banks); i++ {
+		traces = append(traces, autofunc.Slice(state, i*traceSize, (i+1)*traceSize))
+	}
+	if d.hasRuleState() {
+		ruleState = autofunc.Slice(state, len(traces)*traceSize, len(state.Output()))
+	}
+	return
+}
+
+func (d *DenseLayer) splitStateR(state autofunc.RResult) (traces []autofunc.RResult,
+	ruleState autofunc.RResult) {
+	if len(d.Banks) == 0 && !d.hasRuleState() {
+		return []autofunc.RResult{state}, nil
+	}
+	traceSize := len(d.InitTrace.Vector)
+	for i := 0; i <= len(d.Banks); i++ {
+		traces = append(traces, autofunc.SliceR(state, i*traceSize, (i+1)*traceSize))
+	}
+	if d.hasRuleState() {
+		ruleState = autofunc.SliceR(state, len(traces)*traceSize, len(state.Output()))
+	}
+	return
+}
+
+// hebbTerm computes the plastic entries of the outer
+// product which the trace accumulates.
+//
+// For CovarianceRule, the outputs and inputs are shifted
+// by the thresholds, which come from the rule state or
+// default to the learned ones if ruleState is nil.
+// For STDPRule, the products with the previous output and
+// input from the rule state are added in.
+func (d *DenseLayer) hebbTerm(out, in, ruleState autofunc.Result) autofunc.Result {
+	switch d.Rule {
+	case CovarianceRule:
+		thresholds := ruleState
+		if thresholds == nil {
+			thresholds = d.Thresholds
+		}
+		outCount := d.OutputCount
+		out = autofunc.Sub(out, autofunc.Slice(thresholds, 0, outCount))
+		in = autofunc.Sub(in, autofunc.Slice(thresholds, outCount, outCount+d.InputCount))
+	case STDPRule:
+		return d.gatherPlastic(d.timingProduct(out, in, ruleState))
+	}
+	return d.gatherPlastic(autofunc.OuterProduct(out, in))
+}
+
+func (d *DenseLayer) hebbTermR(rv autofunc.RVector, out, in,
+	ruleState autofunc.RResult) autofunc.RResult {
+	switch d.Rule {
+	case CovarianceRule:
+		thresholds := ruleState
+		if thresholds == nil {
+			thresholds = autofunc.NewRVariable(d.Thresholds, rv)
+		}
+		outCount := d.OutputCount
+		out = autofunc.SubR(out, autofunc.SliceR(thresholds, 0, outCount))
+		in = autofunc.SubR(in, autofunc.SliceR(thresholds, outCount, outCount+d.InputCount))
+	case STDPRule:
+		return d.gatherPlasticR(d.timingProductR(rv, out, in, ruleState))
+	}
+	return d.gatherPlasticR(autofunc.OuterProductR(out, in))
+}
+
+// updateRuleState computes the next rule state.
+func (d *DenseLayer) updateRuleState(ruleState, out, in autofunc.Result) autofunc.Result {
+	if d.Rule == STDPRule {
+		return autofunc.Concat(out, in)
+	}
+	return d.updateThresholds(ruleState, out, in)
+}
+
+func (d *DenseLayer) updateRuleStateR(rv autofunc.RVector, ruleState, out,
+	in autofunc.RResult) autofunc.RResult {
+	if d.Rule == STDPRule {
+		return autofunc.ConcatR(out, in)
+	}
+	return d.updateThresholdsR(rv, ruleState, out, in)
+}
+
+// updateThresholds moves the running thresholds towards
+// the current outputs and inputs.
+func (d *DenseLayer) updateThresholds(thresholds, out, in autofunc.Result) autofunc.Result {
+	rate := neuralnet.Sigmoid{}.Apply(d.ThresholdRate)
+	keepRate := autofunc.AddScaler(autofunc.Scale(rate, -1), 1)
+	return autofunc.Add(scaleByRate(thresholds, keepRate),
+		scaleByRate(autofunc.Concat(out, in), rate))
+}
+
+func (d *DenseLayer) updateThresholdsR(rv autofunc.RVector, thresholds, out,
+	in autofunc.RResult) autofunc.RResult {
+	rate := neuralnet.Sigmoid{}.ApplyR(rv, autofunc.NewRVariable(d.ThresholdRate, rv))
+	keepRate := autofunc.AddScalerR(autofunc.ScaleR(rate, -1), 1)
+	return autofunc.AddR(scaleByRateR(thresholds, keepRate),
+		scaleByRateR(autofunc.ConcatR(out, in), rate))
+}
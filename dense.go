@@ -12,11 +12,84 @@ import (
 	"github.com/unixpickle/weakai/rnn"
 )
 
+// These are the serializer IDs for DenseLayer.
+// Layers with a binary Encoding use their own ID, but
+// DeserializeDenseLayer reads both formats.
+const (
+	denseLayerType       = "github.com/unixpickle/hebbnet.DenseLayer"
+	denseLayerBinaryType = "github.com/unixpickle/hebbnet.DenseLayerBinary"
+)
+
 func init() {
-	var d DenseLayer
-	serializer.RegisterTypedDeserializer(d.SerializerType(), DeserializeDenseLayer)
+	serializer.RegisterTypedDeserializer(denseLayerType, DeserializeDenseLayer)
+	serializer.RegisterTypedDeserializer(denseLayerBinaryType, DeserializeDenseLayer)
 }
 
+// A PlasticityRule determines how a Hebbian trace evolves
+// between timesteps.
+type PlasticityRule int
+
+const (
+	// HebbRule makes the trace a decaying average of the
+	// outer products of outputs and inputs:
+	//
+	//     trace = (1-eta)*trace + eta*y*x
+	HebbRule PlasticityRule = iota
+
+	// OjaRule uses Oja's rule, which keeps each row of
+	// the trace from growing without bound:
+	//
+	//     trace = trace + eta*y*(x - y*trace)
+	OjaRule
+
+	// CovarianceRule makes the trace a decaying average of
+	// the covariance between outputs and inputs, as in the
+	// BCM rule:
+	//
+	//     trace = (1-eta)*trace + eta*(y-thetaY)*(x-thetaX)
+	//
+	// The thresholds are either learned parameters or
+	// running averages of the outputs and inputs.
+	// See UseCovarianceRule.
+	CovarianceRule
+
+	// STDPRule is a temporally asymmetric version of
+	// HebbRule, inspired by spike-timing-dependent
+	// plasticity.
+	// Besides y*x, the trace accumulates the products of
+	// the current output with the previous input and of the
+	// previous output with the current input:
+	//
+	//     trace = (1-eta)*trace + eta*(y*x + a*y*x' + b*y'*x)
+	//
+	// The previous output y' and input x' are stored in the
+	// layer's state, and a and b are learned coefficients.
+	// See UseSTDPRule.
+	STDPRule
+)
+
+// A RateGranularity determines how many trace rates a
+// DenseLayer has and how they are shared between
+// connections.
+type RateGranularity int
+
+const (
+	// PerWeightRates uses either one rate shared by every
+	// connection or one rate per plastic connection,
+	// depending on the length of the TraceRate vector.
+	PerWeightRates RateGranularity = iota
+
+	// PerRowRates uses one rate per output neuron, shared
+	// by all of the neuron's incoming connections.
+	PerRowRates
+
+	// PerRowColumnRates uses one rate per output neuron
+	// followed by one rate per input.
+	// The rate for a connection is the sum of its row and
+	// column rates (before squashing).
+	PerRowColumnRates
+)
+
 // A DenseLayer is a fully-connected recurrent layer with
 // Hebbian plasticities as well as standard weights.
 type DenseLayer struct {
@@ -25,13 +98,42 @@ type DenseLayer struct {
 
 	// TraceRate specifies how much the Hebbian trace can
 	// change between timesteps.
-	// Either this will contain one value (a single rate)
-	// or multiple values (one rate per weight).
+	// Its layout is determined by RateGranularity.
 	// The rate is squashed between 0 and 1 before being
 	// used, where 0 means no change and 1 means complete
 	// change.
 	TraceRate *autofunc.Variable
 
+	// RateGranularity determines how trace rates are
+	// shared between connections.
+	RateGranularity RateGranularity
+
+	// DecayRate, if non-nil, specifies how quickly the
+	// Hebbian trace decays, independently of TraceRate.
+	// It has as many values as TraceRate, is laid out the
+	// same way, and is squashed the same way, where 0 means
+	// no decay and 1 means the trace is forgotten entirely
+	// at every step.
+	//
+	// If DecayRate is nil, the decay is tied to the write
+	// rate: the trace keeps a fraction 1-r of its value,
+	// where r is the squashed TraceRate.
+	// See UntieDecay.
+	//
+	// DecayRate only applies to the first trace bank; the
+	// traces in Banks always tie their decay to their write
+	// rates.
+	DecayRate *autofunc.Variable
+
+	// Banks stores additional Hebbian traces for every
+	// plastic connection, each with its own trace rates,
+	// plasticities, and initial trace.
+	// TraceRate, Plasticities, and InitTrace make up the
+	// first bank, and the Hebbian terms of all the banks are
+	// summed.
+	// See AddTraceBank.
+	Banks []*TraceBank
+
 	// Weights stores the weight matrix of the layer in a
 	// row-major format.
 	// There are InputCount columns and OutputCount rows.
@@ -42,22 +144,103 @@ type DenseLayer struct {
 
 	// Plasticities is a matrix containing the plasticity
 	// for each connection.
-	// It is layed out like Weights in memory.
+	// It is layed out like Weights in memory, except that
+	// entries for non-plastic connections are omitted.
 	Plasticities *autofunc.Variable
 
 	// InitTrace is the initial value for the Hebbian trace.
+	// Like Plasticities, it omits non-plastic connections.
 	InitTrace *autofunc.Variable
 
-	// If UseActivation is true, then outputs of the layer
-	// are fed into hyperbolic tangent and the tangents
-	// are used to compute the Hebbian trace.
+	// PlasticIndices lists the indices (in Weights) of the
+	// plastic connections, in ascending order.
+	// If it is nil, every connection is plastic.
+	// See SetPlasticMask.
+	PlasticIndices []int
+
+	// Activation is the activation function applied to the
+	// outputs of the layer.
+	// If it is nil, the behavior depends on UseActivation.
+	Activation Activation
+
+	// If UseActivation is true and Activation is nil, then
+	// outputs of the layer are fed into hyperbolic tangent.
+	//
+	// This exists for compatibility with older models.
+	// New code should set Activation instead.
 	UseActivation bool
+
+	// If HebbPreActivation is true, then the Hebbian trace
+	// is computed using the outputs of the layer before
+	// the activation function is applied.
+	// Otherwise, the activated outputs are used.
+	HebbPreActivation bool
+
+	// Rule specifies how the Hebbian trace is updated.
+	// The zero value is HebbRule.
+	Rule PlasticityRule
+
+	// Thresholds stores the thresholds used by
+	// CovarianceRule: one per output followed by one per
+	// input.
+	//
+	// If ThresholdRate is nil, these are learned constants.
+	// Otherwise, they are the initial values of running
+	// averages, which are stored in the layer's state after
+	// the Hebbian trace.
+	Thresholds *autofunc.Variable
+
+	// ThresholdRate, if non-nil, makes the thresholds of
+	// CovarianceRule running averages.
+	// It contains either one value or one value per
+	// threshold, and is squashed like TraceRate to decide
+	// how quickly the averages change.
+	ThresholdRate *autofunc.Variable
+
+	// TimingCoeffs stores the coefficients a and b used by
+	// STDPRule, which weigh the forward (current output,
+	// previous input) and backward (previous output,
+	// current input) products respectively.
+	TimingCoeffs *autofunc.Variable
+
+	// TraceClip, if non-zero, clips every entry of the
+	// Hebbian trace to the range [-TraceClip, TraceClip]
+	// after each update.
+	TraceClip float64
+
+	// TraceRowNorm, if non-zero, bounds the L2 norm of each
+	// row of the Hebbian trace after each update.
+	// Rows with larger norms are scaled down to have norm
+	// TraceRowNorm.
+	TraceRowNorm float64
+
+	// InputSigns, if non-nil, constrains the signs of the
+	// weights and plasticities of each input's connections,
+	// in the spirit of Dale's law.
+	// It contains one InputSign per input.
+	//
+	// The constraints are enforced by reparameterization:
+	// for constrained connections, Weights and Plasticities
+	// (including those in Banks) store unconstrained values
+	// x, and the layer uses s*log(1+exp(x)), where s is 1
+	// for excitatory inputs and -1 for inhibitory ones.
+	// See ConstrainSigns and EffectiveWeights.
+	InputSigns []InputSign
+
+	// Encoding determines how Serialize encodes the layer.
+	// The zero value is JSONEncoding.
+	Encoding Encoding
 }
 
 // DeserializeDenseLayer deserializes a DenseLayer.
+// It accepts both the JSON and the binary encodings.
 func DeserializeDenseLayer(d []byte) (*DenseLayer, error) {
 	var res DenseLayer
-	if err := json.Unmarshal(d, &res); err != nil {
+	if isBinaryDenseLayer(d) {
+		if err := res.decodeBinary(d); err != nil {
+			return nil, err
+		}
+	} else if err := json.Unmarshal(d, &res); err != nil {
 		return nil, err
 	}
 	return &res, nil
@@ -89,171 +272,530 @@ func NewDenseLayer(inCount, outCount int, variableRate bool) *DenseLayer {
 	return res
 }
 
+// NewDenseLayerRates is like NewDenseLayer, but it uses
+// the given trace rate granularity.
+// With PerWeightRates, every connection gets its own rate.
+func NewDenseLayerRates(inCount, outCount int, g RateGranularity) *DenseLayer {
+	res := NewDenseLayer(inCount, outCount, g == PerWeightRates)
+	res.RateGranularity = g
+	switch g {
+	case PerRowRates:
+		res.TraceRate.Vector = make(linalg.Vector, outCount)
+	case PerRowColumnRates:
+		res.TraceRate.Vector = make(linalg.Vector, outCount+inCount)
+	}
+	return res
+}
+
 // InitRates randomly initializes the trace rates in a
 // biased fashion.
 // The rates are divided up into three sections: long-term
 // short-term, and neutral.
 // The arguments specify, out of all rates, the fraction
 // of long-term and short-term ones.
+//
+// This is equivalent to using a BucketRates initializer.
 func (d *DenseLayer) InitRates(longTerm, shortTerm float64) {
-	indices := rand.Perm(len(d.TraceRate.Vector))
-	lt := int(math.Ceil(longTerm * float64(len(indices))))
-	st := int(math.Ceil(shortTerm * float64(len(indices))))
-	for lt+st > len(indices) {
-		if st > 0 {
-			st--
-		} else {
-			lt--
+	d.InitRatesWith(&BucketRates{LongTerm: longTerm, ShortTerm: shortTerm})
+}
+
+// InitRatesWith initializes the trace rates using the
+// given initializer.
+//
+// With PerRowColumnRates, the initializer is applied to
+// the row rates and the column rates are set to 0, so
+// that every connection starts with its row's rate.
+func (d *DenseLayer) InitRatesWith(r RateInitializer) {
+	d.initRates(d.TraceRate.Vector, r)
+}
+
+// initRates initializes a vector laid out like TraceRate
+// using the given initializer.
+func (d *DenseLayer) initRates(rates linalg.Vector, r RateInitializer) {
+	if d.RateGranularity == PerRowColumnRates {
+		r.InitRates(rates[:d.OutputCount])
+		for i := d.OutputCount; i < len(rates); i++ {
+			rates[i] = 0
 		}
+		return
 	}
-	for _, i := range indices[:lt] {
-		d.TraceRate.Vector[i] = rand.Float64() - 2
-	}
-	for _, i := range indices[lt:st] {
-		d.TraceRate.Vector[i] = rand.Float64() + 2
-	}
+	r.InitRates(rates)
+}
+
+// UntieDecay gives the layer a DecayRate parameter, so
+// that the trace's decay is learned separately from its
+// write rate.
+// The decay rates are initialized to the trace rates, so
+// that the layer behaves exactly as it did before.
+//
+// The exception is OjaRule, whose tied trace is only
+// decayed by Oja's forgetting term.
+// Untying the decay adds a separate decay, which starts
+// out equal to the write rate.
+func (d *DenseLayer) UntieDecay() {
+	d.DecayRate = &autofunc.Variable{Vector: d.TraceRate.Vector.Copy()}
 }
 
 // Parameters returns the layer's learnable parameters.
 func (d *DenseLayer) Parameters() []*autofunc.Variable {
-	return []*autofunc.Variable{
+	res := []*autofunc.Variable{
 		d.TraceRate,
 		d.Weights,
 		d.Biases,
 		d.Plasticities,
 		d.InitTrace,
 	}
+	if d.DecayRate != nil {
+		res = append(res, d.DecayRate)
+	}
+	for _, b := range d.Banks {
+		res = append(res, b.TraceRate, b.Plasticities, b.InitTrace)
+	}
+	if d.Rule == CovarianceRule {
+		res = append(res, d.Thresholds)
+		if d.ThresholdRate != nil {
+			res = append(res, d.ThresholdRate)
+		}
+	} else if d.Rule == STDPRule {
+		res = append(res, d.TimingCoeffs)
+	}
+	return res
 }
 
-// StartState returns the initial trace.
+// StartState returns the initial trace of every bank,
+// followed by the initial rule state, if any.
+// See splitState.
 func (d *DenseLayer) StartState() rnn.State {
-	return rnn.VecState(d.InitTrace.Vector)
+	return startVecState(d.startVars())
 }
 
-// StartRState returns the initial trace.
+// StartRState returns the initial trace of every bank,
+// followed by the initial rule state, if any.
+// See splitState.
 func (d *DenseLayer) StartRState(rv autofunc.RVector) rnn.RState {
-	rvar := autofunc.NewRVariable(d.InitTrace, rv)
-	return rnn.VecRState{State: rvar.Output(), RState: rvar.ROutput()}
+	return startVecRState(rv, d.startVars())
 }
 
 // PropagateStart propagates through the start state.
 func (d *DenseLayer) PropagateStart(_ []rnn.State, s []rnn.StateGrad, g autofunc.Gradient) {
-	rnn.PropagateVarState(d.InitTrace, s, g)
+	propagateStartVars(d.startVars(), s, g)
 }
 
 // PropagateStartR propagates through the start state.
 func (d *DenseLayer) PropagateStartR(_ []rnn.RState, s []rnn.RStateGrad, rg autofunc.RGradient,
 	g autofunc.Gradient) {
-	rnn.PropagateVarStateR(d.InitTrace, s, rg, g)
+	propagateStartVarsR(d.startVars(), s, rg, g)
 }
 
 // ApplyBlock applies the layer to a batch of inputs.
+//
+// The weight products for all of the sequences in the
+// batch are computed with a single matrix-matrix product.
+// See BenchmarkDenseBatched and BenchmarkDenseSequential
+// to compare this with processing sequences one by one.
 func (d *DenseLayer) ApplyBlock(s []rnn.State, in []autofunc.Result) rnn.BlockResult {
-	res := &denseLayerOutput{}
-	res.StatePool, _ = rnn.PoolVecStates(s)
-	for i, input := range in {
-		newState, out := d.timestep(res.StatePool[i], input)
-		res.StateResults = append(res.StateResults, newState)
-		res.OutResults = append(res.OutResults, out)
-		res.StatesOut = append(res.StatesOut, rnn.VecState(newState.Output()))
-		res.VecsOut = append(res.VecsOut, out.Output())
-	}
-	return res
+	return d.applyBatched(s, in)
 }
 
-// BatchR applies the layer to a set of inputs.
+// ApplyBlockR applies the layer to a batch of inputs.
 func (d *DenseLayer) ApplyBlockR(rv autofunc.RVector, s []rnn.RState,
 	in []autofunc.RResult) rnn.BlockRResult {
-	res := &denseLayerROutput{}
-	var pool []autofunc.RResult
-	res.StatePool, pool = rnn.PoolVecRStates(s)
-	for i, input := range in {
-		newState, out := d.timestepR(rv, pool[i], input)
-		res.StateResults = append(res.StateResults, newState)
-		res.OutResults = append(res.OutResults, out)
-		res.VecsOut = append(res.VecsOut, out.Output())
-		res.RVecsOut = append(res.RVecsOut, out.ROutput())
-		res.StatesOut = append(res.StatesOut, rnn.VecRState{
-			State:  newState.Output(),
-			RState: newState.ROutput(),
-		})
-	}
-	return res
+	return applyBlockR(s, in, func(state, in autofunc.RResult) (newState, out autofunc.RResult) {
+		return d.timestepR(rv, state, in)
+	})
 }
 
 // SerializerType returns the unique ID used to serialize
 // this type with the serializer package.
+// Layers with a binary Encoding use a separate ID.
 func (d *DenseLayer) SerializerType() string {
-	return "github.com/unixpickle/hebbnet.DenseLayer"
+	if d.Encoding != JSONEncoding {
+		return denseLayerBinaryType
+	}
+	return denseLayerType
 }
 
-// Serialize serializes the layer.
+// Serialize serializes the layer using its Encoding.
 func (d *DenseLayer) Serialize() ([]byte, error) {
+	if d.Encoding != JSONEncoding {
+		return d.encodeBinary()
+	}
 	return json.Marshal(d)
 }
 
 func (d *DenseLayer) timestep(state, in autofunc.Result) (newState, out autofunc.Result) {
-	weightTran := autofunc.LinTran{
-		Data: d.Weights,
-		Rows: d.OutputCount,
-		Cols: d.InputCount,
+	out, hebbOut := d.output(state, in)
+	newState = d.updateTrace(state, hebbOut, in, d.traceRates())
+	return
+}
+
+func (d *DenseLayer) timestepR(rv autofunc.RVector, state,
+	in autofunc.RResult) (newState, out autofunc.RResult) {
+	out, hebbOut := d.outputR(rv, state, in)
+	newState = d.updateTraceR(rv, state, hebbOut, in, d.traceRatesR(rv))
+	return
+}
+
+// traceRates computes the squashed trace rate of every
+// bank, each of which has either one value or one value
+// per plastic weight.
+func (d *DenseLayer) traceRates() []autofunc.Result {
+	var res []autofunc.Result
+	for _, b := range d.TraceBanks() {
+		res = append(res, d.squashRates(b.TraceRate))
 	}
-	appliedWeights := weightTran.Apply(in)
-	appliedHebb := autofunc.MatMulVec(autofunc.Mul(d.Plasticities, state),
-		d.OutputCount, d.InputCount, in)
-	out = autofunc.Add(d.Biases, autofunc.Add(appliedWeights, appliedHebb))
-	if d.UseActivation {
-		out = neuralnet.HyperbolicTangent{}.Apply(out)
+	return res
+}
+
+func (d *DenseLayer) traceRatesR(rv autofunc.RVector) []autofunc.RResult {
+	var res []autofunc.RResult
+	for _, b := range d.TraceBanks() {
+		res = append(res, d.squashRatesR(rv, b.TraceRate))
 	}
+	return res
+}
 
-	traceRate := neuralnet.Sigmoid{}.Apply(d.TraceRate)
-	keepRate := autofunc.AddScaler(autofunc.Scale(traceRate, -1), 1)
-	if len(keepRate.Output()) == 1 {
-		newState = autofunc.Add(autofunc.ScaleFirst(state, keepRate),
-			autofunc.ScaleFirst(autofunc.OuterProduct(out, in), traceRate))
-	} else {
-		newState = autofunc.Add(autofunc.Mul(state, keepRate),
-			autofunc.Mul(autofunc.OuterProduct(out, in), traceRate))
+// squashRates squashes a vector of rates laid out like
+// TraceRate, producing either one value or one value per
+// plastic weight.
+func (d *DenseLayer) squashRates(rates *autofunc.Variable) autofunc.Result {
+	switch d.RateGranularity {
+	case PerRowRates:
+		rows, _ := d.plasticCoords()
+		return neuralnet.Sigmoid{}.Apply(gather(rates, rows))
+	case PerRowColumnRates:
+		rows, cols := d.plasticCoords()
+		return neuralnet.Sigmoid{}.Apply(autofunc.Add(gather(rates, rows),
+			gather(rates, cols)))
+	default:
+		return neuralnet.Sigmoid{}.Apply(rates)
 	}
+}
 
+func (d *DenseLayer) squashRatesR(rv autofunc.RVector,
+	rates *autofunc.Variable) autofunc.RResult {
+	rVar := autofunc.NewRVariable(rates, rv)
+	switch d.RateGranularity {
+	case PerRowRates:
+		rows, _ := d.plasticCoords()
+		return neuralnet.Sigmoid{}.ApplyR(rv, gatherR(rVar, rows))
+	case PerRowColumnRates:
+		rows, cols := d.plasticCoords()
+		return neuralnet.Sigmoid{}.ApplyR(rv, autofunc.AddR(gatherR(rVar, rows),
+			gatherR(rVar, cols)))
+	default:
+		return neuralnet.Sigmoid{}.ApplyR(rv, rVar)
+	}
+}
+
+// plasticCoords returns, for every plastic connection,
+// the index of its row rate and the index of its column
+// rate in a PerRowColumnRates TraceRate vector.
+func (d *DenseLayer) plasticCoords() (rows, cols []int) {
+	for i, plastic := range d.PlasticMask() {
+		if plastic {
+			rows = append(rows, i/d.InputCount)
+			cols = append(cols, d.OutputCount+i%d.InputCount)
+		}
+	}
 	return
 }
 
-func (d *DenseLayer) timestepR(rv autofunc.RVector, state,
-	in autofunc.RResult) (newState, out autofunc.RResult) {
+// activation returns the activation function of the
+// layer, or nil if there is none.
+func (d *DenseLayer) activation() Activation {
+	if d.Activation != nil {
+		return d.Activation
+	} else if d.UseActivation {
+		return &neuralnet.HyperbolicTangent{}
+	}
+	return nil
+}
+
+// output computes the output of the layer given the
+// current trace and input.
+// It also returns the output vector which should be used
+// to update the Hebbian trace.
+func (d *DenseLayer) output(state, in autofunc.Result) (out, hebbOut autofunc.Result) {
+	appliedWeights := d.applyWeights(in)
+	traces, _ := d.splitState(state)
+	plasticState := d.scatterPlastic(d.plasticTrace(traces))
+	appliedHebb := autofunc.MatMulVec(plasticState, d.OutputCount, d.InputCount, in)
+	return d.activate(autofunc.Add(d.Biases, autofunc.Add(appliedWeights, appliedHebb)))
+}
+
+func (d *DenseLayer) outputR(rv autofunc.RVector, state,
+	in autofunc.RResult) (out, hebbOut autofunc.RResult) {
+	appliedWeights := d.applyWeightsR(rv, in)
+	traces, _ := d.splitStateR(state)
+	plasticState := d.scatterPlasticR(d.plasticTraceR(rv, traces))
+	appliedHebb := autofunc.MatMulVecR(plasticState, d.OutputCount, d.InputCount, in)
+	return d.activateR(rv, autofunc.AddR(autofunc.NewRVariable(d.Biases, rv),
+		autofunc.AddR(appliedWeights, appliedHebb)))
+}
+
+// applyWeights multiplies the input by the layer's
+// effective weight matrix.
+func (d *DenseLayer) applyWeights(in autofunc.Result) autofunc.Result {
+	if d.InputSigns != nil {
+		return autofunc.MatMulVec(d.weights(), d.OutputCount, d.InputCount, in)
+	}
 	weightTran := autofunc.LinTran{
 		Data: d.Weights,
 		Rows: d.OutputCount,
 		Cols: d.InputCount,
 	}
-	appliedWeights := weightTran.ApplyR(rv, in)
-	plasticState := autofunc.MulR(autofunc.NewRVariable(d.Plasticities, rv), state)
-	appliedHebb := autofunc.MatMulVecR(plasticState, d.OutputCount, d.InputCount, in)
-	out = autofunc.AddR(autofunc.NewRVariable(d.Biases, rv),
-		autofunc.AddR(appliedWeights, appliedHebb))
-	if d.UseActivation {
-		out = neuralnet.HyperbolicTangent{}.ApplyR(rv, out)
+	return weightTran.Apply(in)
+}
+
+func (d *DenseLayer) applyWeightsR(rv autofunc.RVector, in autofunc.RResult) autofunc.RResult {
+	if d.InputSigns != nil {
+		return autofunc.MatMulVecR(d.weightsR(rv), d.OutputCount, d.InputCount, in)
 	}
+	weightTran := autofunc.LinTran{
+		Data: d.Weights,
+		Rows: d.OutputCount,
+		Cols: d.InputCount,
+	}
+	return weightTran.ApplyR(rv, in)
+}
 
-	traceRate := neuralnet.Sigmoid{}.ApplyR(rv, autofunc.NewRVariable(d.TraceRate, rv))
-	keepRate := autofunc.AddScalerR(autofunc.ScaleR(traceRate, -1), 1)
-	if len(keepRate.Output()) == 1 {
-		newState = autofunc.AddR(autofunc.ScaleFirstR(state, keepRate),
-			autofunc.ScaleFirstR(autofunc.OuterProductR(out, in), traceRate))
-	} else {
-		newState = autofunc.AddR(autofunc.MulR(state, keepRate),
-			autofunc.MulR(autofunc.OuterProductR(out, in), traceRate))
+// activate applies the activation function to the
+// pre-activation outputs of the layer.
+// It also returns the output vector which should be used
+// to update the Hebbian trace.
+func (d *DenseLayer) activate(preAct autofunc.Result) (out, hebbOut autofunc.Result) {
+	out, hebbOut = preAct, preAct
+	if act := d.activation(); act != nil {
+		out = act.Apply(preAct)
+		if !d.HebbPreActivation {
+			hebbOut = out
+		}
 	}
+	return
+}
 
+func (d *DenseLayer) activateR(rv autofunc.RVector,
+	preAct autofunc.RResult) (out, hebbOut autofunc.RResult) {
+	out, hebbOut = preAct, preAct
+	if act := d.activation(); act != nil {
+		out = act.ApplyR(rv, preAct)
+		if !d.HebbPreActivation {
+			hebbOut = out
+		}
+	}
 	return
 }
 
+// updateTrace computes the next state, which contains the
+// Hebbian trace of every bank and possibly rule state.
+// The traceRates argument contains the squashed trace
+// rate of every bank, each of which may contain either one
+// value or one value per plastic weight.
+func (d *DenseLayer) updateTrace(state, out, in autofunc.Result,
+	traceRates []autofunc.Result) autofunc.Result {
+	traces, ruleState := d.splitState(state)
+	var newTraces []autofunc.Result
+	for i, trace := range traces {
+		decayRate := d.DecayRate
+		if i > 0 {
+			decayRate = nil
+		}
+		res := d.unboundedTrace(trace, ruleState, out, in, traceRates[i], decayRate)
+		newTraces = append(newTraces, d.boundTrace(res))
+	}
+	if ruleState != nil {
+		newTraces = append(newTraces, d.updateRuleState(ruleState, out, in))
+	}
+	if len(newTraces) == 1 {
+		return newTraces[0]
+	}
+	return autofunc.Concat(newTraces...)
+}
+
+func (d *DenseLayer) updateTraceR(rv autofunc.RVector, state, out, in autofunc.RResult,
+	traceRates []autofunc.RResult) autofunc.RResult {
+	traces, ruleState := d.splitStateR(state)
+	var newTraces []autofunc.RResult
+	for i, trace := range traces {
+		decayRate := d.DecayRate
+		if i > 0 {
+			decayRate = nil
+		}
+		res := d.unboundedTraceR(rv, trace, ruleState, out, in, traceRates[i], decayRate)
+		newTraces = append(newTraces, d.boundTraceR(res))
+	}
+	if ruleState != nil {
+		newTraces = append(newTraces, d.updateRuleStateR(rv, ruleState, out, in))
+	}
+	if len(newTraces) == 1 {
+		return newTraces[0]
+	}
+	return autofunc.ConcatR(newTraces...)
+}
+
+// boundTrace applies TraceClip and TraceRowNorm to the
+// updated trace of a single bank.
+func (d *DenseLayer) boundTrace(trace autofunc.Result) autofunc.Result {
+	if d.TraceClip != 0 {
+		trace = clipTrace(trace, d.TraceClip)
+	}
+	if d.TraceRowNorm != 0 {
+		trace = d.gatherPlastic(boundRowNorms(d.scatterPlastic(trace), d.InputCount,
+			d.TraceRowNorm))
+	}
+	return trace
+}
+
+func (d *DenseLayer) boundTraceR(trace autofunc.RResult) autofunc.RResult {
+	if d.TraceClip != 0 {
+		trace = clipTraceR(trace, d.TraceClip)
+	}
+	if d.TraceRowNorm != 0 {
+		trace = d.gatherPlasticR(boundRowNormsR(d.scatterPlasticR(trace), d.InputCount,
+			d.TraceRowNorm))
+	}
+	return trace
+}
+
+// unboundedTrace updates the trace of a single bank.
+// If decayRate is nil, the decay is tied to the write
+// rate.
+func (d *DenseLayer) unboundedTrace(state, ruleState, out, in, traceRate autofunc.Result,
+	decayRate *autofunc.Variable) autofunc.Result {
+	switch d.Rule {
+	case OjaRule:
+		ones := constOnes(d.InputCount)
+		outSquared := d.gatherPlastic(autofunc.OuterProduct(autofunc.Mul(out, out), ones))
+		forget := autofunc.Mul(outSquared, state)
+		change := autofunc.Sub(d.gatherPlastic(autofunc.OuterProduct(out, in)), forget)
+		if decayRate != nil {
+			state = scaleByRate(state, d.keepRate(traceRate, decayRate))
+		}
+		return autofunc.Add(state, scaleByRate(change, traceRate))
+	default:
+		return autofunc.Add(scaleByRate(state, d.keepRate(traceRate, decayRate)),
+			scaleByRate(d.hebbTerm(out, in, ruleState), traceRate))
+	}
+}
+
+func (d *DenseLayer) unboundedTraceR(rv autofunc.RVector, state, ruleState, out, in,
+	traceRate autofunc.RResult, decayRate *autofunc.Variable) autofunc.RResult {
+	switch d.Rule {
+	case OjaRule:
+		ones := autofunc.NewRVariable(constOnes(d.InputCount), rv)
+		outSquared := d.gatherPlasticR(autofunc.OuterProductR(autofunc.MulR(out, out), ones))
+		forget := autofunc.MulR(outSquared, state)
+		change := autofunc.SubR(d.gatherPlasticR(autofunc.OuterProductR(out, in)), forget)
+		if decayRate != nil {
+			state = scaleByRateR(state, d.keepRateR(rv, traceRate, decayRate))
+		}
+		return autofunc.AddR(state, scaleByRateR(change, traceRate))
+	default:
+		return autofunc.AddR(scaleByRateR(state, d.keepRateR(rv, traceRate, decayRate)),
+			scaleByRateR(d.hebbTermR(rv, out, in, ruleState), traceRate))
+	}
+}
+
+// keepRate computes the fraction of the trace which is
+// kept between timesteps.
+// If decayRate is nil, the decay is tied to the write
+// rate, so this is based on the (possibly modulated)
+// squashed trace rate.
+func (d *DenseLayer) keepRate(traceRate autofunc.Result,
+	decayRate *autofunc.Variable) autofunc.Result {
+	decay := traceRate
+	if decayRate != nil {
+		decay = d.squashRates(decayRate)
+	}
+	return autofunc.AddScaler(autofunc.Scale(decay, -1), 1)
+}
+
+func (d *DenseLayer) keepRateR(rv autofunc.RVector, traceRate autofunc.RResult,
+	decayRate *autofunc.Variable) autofunc.RResult {
+	decay := traceRate
+	if decayRate != nil {
+		decay = d.squashRatesR(rv, decayRate)
+	}
+	return autofunc.AddScalerR(autofunc.ScaleR(decay, -1), 1)
+}
+
+// scaleByRate scales v by a trace rate, which may either
+// be a single value or one value per component of v.
+func scaleByRate(v, rate autofunc.Result) autofunc.Result {
+	if len(rate.Output()) == 1 {
+		return autofunc.ScaleFirst(v, rate)
+	}
+	return autofunc.Mul(v, rate)
+}
+
+func scaleByRateR(v, rate autofunc.RResult) autofunc.RResult {
+	if len(rate.Output()) == 1 {
+		return autofunc.ScaleFirstR(v, rate)
+	}
+	return autofunc.MulR(v, rate)
+}
+
+// constOnes creates a constant variable filled with ones.
+func constOnes(n int) *autofunc.Variable {
+	res := &autofunc.Variable{Vector: make(linalg.Vector, n)}
+	for i := range res.Vector {
+		res.Vector[i] = 1
+	}
+	return res
+}
+
+// applyBlock runs a timestep function on every input in
+// a batch and packages the results as an rnn.BlockResult.
+// The states must be rnn.VecStates.
+func applyBlock(s []rnn.State, in []autofunc.Result,
+	f func(state, in autofunc.Result) (newState, out autofunc.Result)) rnn.BlockResult {
+	res := &denseLayerOutput{}
+	res.StatePool, _ = rnn.PoolVecStates(s)
+	for i, input := range in {
+		newState, out := f(res.StatePool[i], input)
+		res.StateResults = append(res.StateResults, newState)
+		res.OutResults = append(res.OutResults, out)
+		res.StatesOut = append(res.StatesOut, rnn.VecState(newState.Output()))
+		res.VecsOut = append(res.VecsOut, out.Output())
+	}
+	return res
+}
+
+// applyBlockR is like applyBlock for RResults.
+func applyBlockR(s []rnn.RState, in []autofunc.RResult,
+	f func(state, in autofunc.RResult) (newState, out autofunc.RResult)) rnn.BlockRResult {
+	res := &denseLayerROutput{}
+	var pool []autofunc.RResult
+	res.StatePool, pool = rnn.PoolVecRStates(s)
+	for i, input := range in {
+		newState, out := f(pool[i], input)
+		res.StateResults = append(res.StateResults, newState)
+		res.OutResults = append(res.OutResults, out)
+		res.VecsOut = append(res.VecsOut, out.Output())
+		res.RVecsOut = append(res.RVecsOut, out.ROutput())
+		res.StatesOut = append(res.StatesOut, rnn.VecRState{
+			State:  newState.Output(),
+			RState: newState.ROutput(),
+		})
+	}
+	return res
+}
+
 type denseLayerOutput struct {
 	StatePool    []*autofunc.Variable
 	VecsOut      []linalg.Vector
 	StatesOut    []rnn.State
 	OutResults   []autofunc.Result
 	StateResults []autofunc.Result
+
+	// Batch, if non-nil, is a result that was computed for
+	// the entire batch at once.
+	// The per-sequence results use BatchPool in its place,
+	// and gradients are propagated through Batch after the
+	// per-sequence results.
+	Batch     autofunc.Result
+	BatchPool *autofunc.Variable
 }
 
 func (d *denseLayerOutput) Outputs() []linalg.Vector {
@@ -270,6 +812,10 @@ func (d *denseLayerOutput) PropagateGradient(u []linalg.Vector, s []rnn.StateGra
 		return nil
 	}
 	return rnn.PropagateVecStatePool(g, d.StatePool, func() {
+		propagateBatch := d.Batch != nil && !d.Batch.Constant(g)
+		if propagateBatch {
+			g[d.BatchPool] = make(linalg.Vector, len(d.BatchPool.Vector))
+		}
 		tempStateUpstream := make(linalg.Vector, len(d.StatesOut[0].(rnn.VecState)))
 		tempOutUpstream := make(linalg.Vector, len(d.VecsOut[0]))
 		for i := range d.VecsOut {
@@ -286,6 +832,11 @@ func (d *denseLayerOutput) PropagateGradient(u []linalg.Vector, s []rnn.StateGra
 			d.OutResults[i].PropagateGradient(tempOutUpstream, g)
 			d.StateResults[i].PropagateGradient(tempStateUpstream, g)
 		}
+		if propagateBatch {
+			batchUpstream := g[d.BatchPool]
+			delete(g, d.BatchPool)
+			d.Batch.PropagateGradient(batchUpstream, g)
+		}
 	})
 }
 
@@ -1,11 +1,16 @@
 package hebbnet
 
 import (
+	"math"
 	"math/rand"
 	"testing"
 
 	"github.com/unixpickle/autofunc"
 	"github.com/unixpickle/num-analysis/linalg"
+	"github.com/unixpickle/serializer"
+	"github.com/unixpickle/sgd"
+	"github.com/unixpickle/weakai/neuralnet"
+	"github.com/unixpickle/weakai/rnn"
 	"github.com/unixpickle/weakai/rnn/rnntest"
 )
 
@@ -43,3 +48,337 @@ func TestDense(t *testing.T) {
 	}
 	checker.FullCheck(t)
 }
+
+func TestDenseOja(t *testing.T) {
+	block := NewDenseLayer(4, 2, true)
+	block.Rule = OjaRule
+	checkBlock(t, block)
+}
+
+func TestDenseBounded(t *testing.T) {
+	block := NewDenseLayer(4, 2, true)
+	block.TraceClip = 0.05
+	block.TraceRowNorm = 0.08
+	checkBlock(t, block)
+}
+
+func TestDenseMasked(t *testing.T) {
+	block := NewDenseLayer(4, 2, true)
+	block.SetPlasticMask(BlockDiagonalMask(4, 2, 2))
+	if len(block.Plasticities.Vector) != 4 || len(block.TraceRate.Vector) != 4 {
+		t.Fatal("unexpected number of plastic parameters")
+	}
+	block.TraceRowNorm = 0.08
+	checkBlock(t, block)
+}
+
+func TestDenseMaskInvalid(t *testing.T) {
+	block := NewDenseLayer(4, 2, true)
+	expectPanic(t, "short mask", func() {
+		block.SetPlasticMask(make([]bool, 7))
+	})
+	expectPanic(t, "long mask", func() {
+		block.SetPlasticMask(make([]bool, 9))
+	})
+}
+
+func TestDenseDecay(t *testing.T) {
+	for _, rule := range []PlasticityRule{HebbRule, OjaRule} {
+		block := NewDenseLayer(4, 2, true)
+		block.Rule = rule
+		block.UntieDecay()
+		for i := range block.DecayRate.Vector {
+			block.DecayRate.Vector[i] = rand.NormFloat64()
+		}
+		checkBlock(t, block)
+	}
+}
+
+func TestDenseUntieDecay(t *testing.T) {
+	layer := NewDenseLayer(4, 2, true)
+	layer.UseActivation = true
+	layer.InitRates(0.1, 0.3)
+	states, inputs := randomBatch(layer, 3)
+	expected := layer.ApplyBlock(states, inputs)
+	layer.UntieDecay()
+	actual := layer.ApplyBlock(states, inputs)
+	for i, exp := range expected.Outputs() {
+		if vecDiff(exp, actual.Outputs()[i]) > 1e-8 {
+			t.Errorf("seq %d: expected output %v but got %v", i, exp, actual.Outputs()[i])
+		}
+		expState := linalg.Vector(expected.States()[i].(rnn.VecState))
+		actState := linalg.Vector(actual.States()[i].(rnn.VecState))
+		if vecDiff(expState, actState) > 1e-8 {
+			t.Errorf("seq %d: expected state %v but got %v", i, expState, actState)
+		}
+	}
+}
+
+func TestDenseUntieDecayRates(t *testing.T) {
+	layer := NewDenseLayerRates(4, 2, PerRowColumnRates)
+	layer.InitRates(0.1, 0.3)
+	layer.UntieDecay()
+	checkVec(t, "DecayRate", layer.DecayRate.Vector, layer.TraceRate.Vector)
+	layer.DecayRate.Vector[0]++
+	if layer.DecayRate.Vector[0] == layer.TraceRate.Vector[0] {
+		t.Error("DecayRate shares its vector with TraceRate")
+	}
+}
+
+func TestDenseSerializeDecay(t *testing.T) {
+	block := NewDenseLayer(4, 2, false)
+	block.UntieDecay()
+	block.DecayRate.Vector[0] = 0.7
+	decoded := roundTripDense(t, block)
+	if decoded.DecayRate == nil || len(decoded.DecayRate.Vector) != 1 ||
+		decoded.DecayRate.Vector[0] != 0.7 {
+		t.Errorf("unexpected decay rate: %v", decoded.DecayRate)
+	}
+	if len(decoded.Parameters()) != len(block.Parameters()) {
+		t.Error("unexpected number of parameters")
+	}
+}
+
+func TestDenseCovariance(t *testing.T) {
+	for _, running := range []bool{false, true} {
+		block := NewDenseLayer(4, 2, true)
+		block.UseCovarianceRule(running)
+		for i := range block.Thresholds.Vector {
+			block.Thresholds.Vector[i] = rand.NormFloat64()
+		}
+		checkBlock(t, block)
+	}
+}
+
+func TestDenseSTDP(t *testing.T) {
+	block := NewDenseLayer(4, 2, true)
+	block.UseSTDPRule()
+	block.UseActivation = true
+	checkBlock(t, block)
+
+	block = NewDenseLayerRates(4, 2, PerRowRates)
+	block.UseSTDPRule()
+	block.AddTraceBank(&HalfLifeRates{HalfLives: []float64{5}})
+	block.SetPlasticMask(BlockDiagonalMask(4, 2, 2))
+	checkBlock(t, block)
+}
+
+func TestDenseRowRates(t *testing.T) {
+	checkBlock(t, NewDenseLayerRates(4, 2, PerRowRates))
+	block := NewDenseLayerRates(4, 2, PerRowColumnRates)
+	block.SetPlasticMask(BlockDiagonalMask(4, 2, 2))
+	if len(block.TraceRate.Vector) != 6 {
+		t.Fatal("unexpected number of trace rates")
+	}
+	checkBlock(t, block)
+}
+
+func TestDenseSerializeRowRates(t *testing.T) {
+	block := NewDenseLayerRates(4, 2, PerRowColumnRates)
+	block.InitRates(0.5, 0.5)
+	decoded := roundTripDense(t, block)
+	if decoded.RateGranularity != PerRowColumnRates {
+		t.Errorf("unexpected granularity: %v", decoded.RateGranularity)
+	}
+	expected := block.PlasticRates()
+	actual := decoded.PlasticRates()
+	if len(actual) != 8 || expected.Copy().Scale(-1).Add(actual).MaxAbs() != 0 {
+		t.Errorf("expected rates %v but got %v", expected, actual)
+	}
+}
+
+func TestDenseBanks(t *testing.T) {
+	for _, rule := range []PlasticityRule{HebbRule, OjaRule} {
+		block := NewDenseLayer(4, 2, true)
+		block.Rule = rule
+		block.UntieDecay()
+		block.AddTraceBank(&HalfLifeRates{HalfLives: []float64{1, 20}})
+		block.AddTraceBank(&UniformRates{Min: 0.1, Max: 0.9})
+		block.SetPlasticMask(BlockDiagonalMask(4, 2, 2))
+		block.TraceRowNorm = 0.08
+		for _, b := range block.Banks {
+			for i := range b.Plasticities.Vector {
+				b.Plasticities.Vector[i] = rand.NormFloat64()
+			}
+		}
+		checkBlock(t, block)
+	}
+	block := NewDenseLayer(4, 2, true)
+	block.AddTraceBank(&HalfLifeRates{HalfLives: []float64{5}})
+	block.UseCovarianceRule(true)
+	checkBlock(t, block)
+}
+
+func TestDenseSerializeBanks(t *testing.T) {
+	block := NewDenseLayerRates(4, 2, PerRowRates)
+	block.AddTraceBank(&HalfLifeRates{HalfLives: []float64{10}})
+	block.Banks[0].Plasticities.Vector[3] = 0.5
+	decoded := roundTripDense(t, block)
+	if len(decoded.Banks) != 1 {
+		t.Fatalf("expected 1 bank but got %d", len(decoded.Banks))
+	}
+	checkVec(t, "bank rates", decoded.PlasticBankRates(1), block.PlasticBankRates(1))
+	checkVec(t, "bank plasticities", decoded.Banks[0].Plasticities.Vector,
+		block.Banks[0].Plasticities.Vector)
+	if len(decoded.StartState().(rnn.VecState)) != 16 {
+		t.Error("unexpected state size")
+	}
+
+	block.Banks[0].InitTrace.Vector = block.Banks[0].InitTrace.Vector[1:]
+	data, _ := serializer.SerializeWithType(block)
+	if _, err := serializer.DeserializeWithType(data); err == nil {
+		t.Error("expected error for invalid bank")
+	}
+}
+
+func TestDenseSigns(t *testing.T) {
+	signs := []InputSign{Excitatory, Inhibitory, Unconstrained, Excitatory}
+	block := NewDenseLayer(4, 2, true)
+	block.AddTraceBank(&HalfLifeRates{HalfLives: []float64{5}})
+	block.SetPlasticMask(BlockDiagonalMask(4, 2, 2))
+	block.ConstrainSigns(signs)
+	for _, b := range block.TraceBanks() {
+		for i := range b.Plasticities.Vector {
+			b.Plasticities.Vector[i] = rand.NormFloat64()
+		}
+	}
+	checkBlock(t, block)
+}
+
+func TestDenseSignsInvalid(t *testing.T) {
+	block := NewDenseLayer(4, 2, true)
+	expectPanic(t, "too few signs", func() {
+		block.ConstrainSigns([]InputSign{Excitatory, Inhibitory})
+	})
+	signs := []InputSign{Excitatory, Inhibitory, Unconstrained, Excitatory}
+	block.ConstrainSigns(signs)
+	weights := block.Weights.Vector.Copy()
+	expectPanic(t, "second call", func() {
+		block.ConstrainSigns(signs)
+	})
+	checkVec(t, "Weights", block.Weights.Vector, weights)
+}
+
+func TestDenseSerializeSigns(t *testing.T) {
+	signs := []InputSign{Excitatory, Inhibitory, Unconstrained}
+	block := NewDenseLayer(3, 2, true)
+	expected := block.Weights.Vector.Copy()
+	block.ConstrainSigns(signs)
+	decoded := roundTripDense(t, block)
+	if len(decoded.InputSigns) != 3 || decoded.InputSigns[1] != Inhibitory {
+		t.Fatalf("unexpected input signs: %v", decoded.InputSigns)
+	}
+	for i, w := range decoded.EffectiveWeights() {
+		exp := expected[i]
+		switch signs[i%3] {
+		case Excitatory:
+			exp = math.Max(math.Abs(exp), minSignedMagnitude)
+		case Inhibitory:
+			exp = -math.Max(math.Abs(exp), minSignedMagnitude)
+		}
+		if math.Abs(w-exp) > 1e-8 {
+			t.Errorf("weight %d: expected %f but got %f", i, exp, w)
+		}
+	}
+	for i, p := range decoded.EffectivePlasticities(0) {
+		if (signs[i%3] == Excitatory && p <= 0) || (signs[i%3] == Inhibitory && p >= 0) {
+			t.Errorf("plasticity %d has wrong sign: %f", i, p)
+		}
+	}
+}
+
+func TestDenseActivation(t *testing.T) {
+	block := NewDenseLayer(4, 2, true)
+	block.Activation = &neuralnet.Sigmoid{}
+	block.HebbPreActivation = true
+	checkBlock(t, block)
+}
+
+func TestDenseSerializeActivation(t *testing.T) {
+	block := NewDenseLayer(4, 2, true)
+	block.Activation = &neuralnet.Sigmoid{}
+	decoded := roundTripDense(t, block)
+	if decoded.Activation == nil ||
+		decoded.Activation.SerializerType() != block.Activation.SerializerType() {
+		t.Errorf("unexpected activation: %T", decoded.Activation)
+	}
+}
+
+func TestDenseLegacyActivation(t *testing.T) {
+	legacy := []byte(`{"InputCount":1,"OutputCount":1,"TraceRate":{"Vector":[0]},` +
+		`"Weights":{"Vector":[2]},"Biases":{"Vector":[0]},"Plasticities":{"Vector":[0]},` +
+		`"InitTrace":{"Vector":[0]},"UseActivation":true}`)
+	block, err := DeserializeDenseLayer(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.DecayRate != nil {
+		t.Error("legacy layer should have tied decay")
+	}
+	in := &autofunc.Variable{Vector: []float64{0.3}}
+	out := block.ApplyBlock([]rnn.State{block.StartState()},
+		[]autofunc.Result{in}).Outputs()[0]
+	if math.Abs(out[0]-math.Tanh(0.6)) > 1e-8 {
+		t.Errorf("expected %f but got %f", math.Tanh(0.6), out[0])
+	}
+}
+
+// roundTripDense serializes and deserializes a layer
+// with the serializer package.
+func roundTripDense(t *testing.T, block *DenseLayer) *DenseLayer {
+	data, err := serializer.SerializeWithType(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := serializer.DeserializeWithType(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return obj.(*DenseLayer)
+}
+
+// expectPanic fails the test if f does not panic.
+func expectPanic(t *testing.T, name string, f func()) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("%s: expected panic", name)
+		}
+	}()
+	f()
+}
+
+// checkBlock runs a gradient check on a block which takes
+// 4-dimensional inputs.
+func checkBlock(t *testing.T, block rnn.Block) {
+	testVars := []*autofunc.Variable{
+		{Vector: []float64{0.098591, -0.595453, -0.751214, 0.266051}},
+		{Vector: []float64{0.988517, 0.107284, -0.331529, 0.028565}},
+		{Vector: []float64{-0.150604, 0.889039, 0.120916, 0.240999}},
+		{Vector: []float64{0.961058, 0.878608, 0.052284, -0.635746}},
+	}
+	testSeqs := [][]*autofunc.Variable{
+		{testVars[0], testVars[2]},
+		{testVars[1]},
+		{testVars[2], testVars[1], testVars[3]},
+	}
+	testRV := autofunc.RVector{
+		testVars[0]: []float64{0.62524, -0.52979, 0.33020, 0.54462},
+		testVars[1]: []float64{0.13498, 0.12607, 0.35989, 0.23255},
+		testVars[2]: []float64{0.85996, 0.68435, -0.68506, 0.96907},
+		testVars[3]: []float64{-0.79095, -0.33867, 0.86759, -0.16159},
+	}
+	for _, v := range block.(sgd.Learner).Parameters() {
+		testVars = append(testVars, v)
+		testRV[v] = make(linalg.Vector, len(v.Vector))
+		for i := range v.Vector {
+			testRV[v][i] = rand.NormFloat64()
+		}
+	}
+	checker := &rnntest.BlockChecker{
+		B:     block,
+		Input: testSeqs,
+		Vars:  testVars,
+		RV:    testRV,
+	}
+	checker.FullCheck(t)
+}
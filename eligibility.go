@@ -0,0 +1,193 @@
+package hebbnet
+
+import (
+	"encoding/json"
+
+	"github.com/unixpickle/autofunc"
+	"github.com/unixpickle/num-analysis/linalg"
+	"github.com/unixpickle/serializer"
+	"github.com/unixpickle/weakai/neuralnet"
+	"github.com/unixpickle/weakai/rnn"
+)
+
+func init() {
+	var e EligibilityLayer
+	serializer.RegisterTypedDeserializer(e.SerializerType(), DeserializeEligibilityLayer)
+}
+
+// An EligibilityLayer is a DenseLayer whose plasticity is
+// conditioned on an external reward signal, for use in
+// reinforcement learning.
+//
+// The last component of every input to the layer is the
+// reward, and the rest of the input is fed to the
+// DenseLayer.
+// Rather than going straight into the Hebbian traces, the
+// Hebbian term of the DenseLayer's rule accumulates into
+// an eligibility trace:
+//
+//	elig = (1-lambda)*elig + lambda*y*x
+//
+// The eligibility trace is then committed to each of the
+// Hebbian traces in proportion to the reward:
+//
+//	trace = trace + eta*reward*elig
+//
+// Thus, the Hebbian traces only change when a non-zero
+// reward arrives.
+// The DenseLayer's trace bounds still apply, but its
+// decay rates are not used, and OjaRule is treated like
+// HebbRule.
+//
+// The state of the layer is the state of the DenseLayer
+// followed by the eligibility trace, which starts at 0.
+type EligibilityLayer struct {
+	// Dense is the underlying layer.
+	// Its inputs do not include the reward.
+	Dense *DenseLayer
+
+	// EligibilityRate determines how quickly the
+	// eligibility trace changes.
+	// It contains either one value or one value per plastic
+	// connection, and is squashed like a trace rate.
+	EligibilityRate *autofunc.Variable
+}
+
+// DeserializeEligibilityLayer deserializes an
+// EligibilityLayer.
+func DeserializeEligibilityLayer(d []byte) (*EligibilityLayer, error) {
+	var res EligibilityLayer
+	if err := json.Unmarshal(d, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// NewEligibilityLayer creates an EligibilityLayer with
+// pre-initialized (semi-randomized) parameters.
+// The inCount argument does not include the reward input.
+// The variableRate argument is passed to NewDenseLayer.
+func NewEligibilityLayer(inCount, outCount int, variableRate bool) *EligibilityLayer {
+	return &EligibilityLayer{
+		Dense:           NewDenseLayer(inCount, outCount, variableRate),
+		EligibilityRate: &autofunc.Variable{Vector: []float64{logit(0.1)}},
+	}
+}
+
+// Parameters returns the layer's learnable parameters.
+func (e *EligibilityLayer) Parameters() []*autofunc.Variable {
+	return append(e.Dense.Parameters(), e.EligibilityRate)
+}
+
+// StartState returns the initial state of the DenseLayer
+// followed by an empty eligibility trace.
+func (e *EligibilityLayer) StartState() rnn.State {
+	return startVecState(e.startVars())
+}
+
+// StartRState returns the initial state of the DenseLayer
+// followed by an empty eligibility trace.
+func (e *EligibilityLayer) StartRState(rv autofunc.RVector) rnn.RState {
+	return startVecRState(rv, e.startVars())
+}
+
+// PropagateStart propagates through the start state.
+func (e *EligibilityLayer) PropagateStart(_ []rnn.State, s []rnn.StateGrad,
+	g autofunc.Gradient) {
+	propagateStartVars(e.startVars(), s, g)
+}
+
+// PropagateStartR propagates through the start state.
+func (e *EligibilityLayer) PropagateStartR(_ []rnn.RState, s []rnn.RStateGrad,
+	rg autofunc.RGradient, g autofunc.Gradient) {
+	propagateStartVarsR(e.startVars(), s, rg, g)
+}
+
+// ApplyBlock applies the layer to a batch of inputs.
+func (e *EligibilityLayer) ApplyBlock(s []rnn.State, in []autofunc.Result) rnn.BlockResult {
+	return applyBlock(s, in, e.timestep)
+}
+
+// ApplyBlockR applies the layer to a batch of inputs.
+func (e *EligibilityLayer) ApplyBlockR(rv autofunc.RVector, s []rnn.RState,
+	in []autofunc.RResult) rnn.BlockRResult {
+	return applyBlockR(s, in, func(state, in autofunc.RResult) (newState, out autofunc.RResult) {
+		return e.timestepR(rv, state, in)
+	})
+}
+
+// SerializerType returns the unique ID used to serialize
+// this type with the serializer package.
+func (e *EligibilityLayer) SerializerType() string {
+	return "github.com/unixpickle/hebbnet.EligibilityLayer"
+}
+
+// Serialize serializes the layer.
+func (e *EligibilityLayer) Serialize() ([]byte, error) {
+	return json.Marshal(e)
+}
+
+func (e *EligibilityLayer) startVars() []*autofunc.Variable {
+	eligibility := &autofunc.Variable{
+		Vector: make(linalg.Vector, len(e.Dense.InitTrace.Vector)),
+	}
+	return append(e.Dense.startVars(), eligibility)
+}
+
+func (e *EligibilityLayer) timestep(state, in autofunc.Result) (newState,
+	out autofunc.Result) {
+	d := e.Dense
+	denseSize := d.stateSize()
+	denseState := autofunc.Slice(state, 0, denseSize)
+	eligibility := autofunc.Slice(state, denseSize, len(state.Output()))
+	x := autofunc.Slice(in, 0, d.InputCount)
+	reward := autofunc.Slice(in, d.InputCount, d.InputCount+1)
+
+	out, hebbOut := d.output(denseState, x)
+	traces, ruleState := d.splitState(denseState)
+
+	rate := neuralnet.Sigmoid{}.Apply(e.EligibilityRate)
+	keepRate := autofunc.AddScaler(autofunc.Scale(rate, -1), 1)
+	newEligibility := autofunc.Add(scaleByRate(eligibility, keepRate),
+		scaleByRate(d.hebbTerm(hebbOut, x, ruleState), rate))
+
+	var parts []autofunc.Result
+	for i, traceRate := range d.traceRates() {
+		commit := autofunc.ScaleFirst(scaleByRate(newEligibility, traceRate), reward)
+		parts = append(parts, d.boundTrace(autofunc.Add(traces[i], commit)))
+	}
+	if ruleState != nil {
+		parts = append(parts, d.updateRuleState(ruleState, hebbOut, x))
+	}
+	newState = autofunc.Concat(append(parts, newEligibility)...)
+	return
+}
+
+func (e *EligibilityLayer) timestepR(rv autofunc.RVector, state,
+	in autofunc.RResult) (newState, out autofunc.RResult) {
+	d := e.Dense
+	denseSize := d.stateSize()
+	denseState := autofunc.SliceR(state, 0, denseSize)
+	eligibility := autofunc.SliceR(state, denseSize, len(state.Output()))
+	x := autofunc.SliceR(in, 0, d.InputCount)
+	reward := autofunc.SliceR(in, d.InputCount, d.InputCount+1)
+
+	out, hebbOut := d.outputR(rv, denseState, x)
+	traces, ruleState := d.splitStateR(denseState)
+
+	rate := neuralnet.Sigmoid{}.ApplyR(rv, autofunc.NewRVariable(e.EligibilityRate, rv))
+	keepRate := autofunc.AddScalerR(autofunc.ScaleR(rate, -1), 1)
+	newEligibility := autofunc.AddR(scaleByRateR(eligibility, keepRate),
+		scaleByRateR(d.hebbTermR(rv, hebbOut, x, ruleState), rate))
+
+	var parts []autofunc.RResult
+	for i, traceRate := range d.traceRatesR(rv) {
+		commit := autofunc.ScaleFirstR(scaleByRateR(newEligibility, traceRate), reward)
+		parts = append(parts, d.boundTraceR(autofunc.AddR(traces[i], commit)))
+	}
+	if ruleState != nil {
+		parts = append(parts, d.updateRuleStateR(rv, ruleState, hebbOut, x))
+	}
+	newState = autofunc.ConcatR(append(parts, newEligibility)...)
+	return
+}
@@ -0,0 +1,47 @@
+package hebbnet
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/unixpickle/autofunc"
+	"github.com/unixpickle/num-analysis/linalg"
+	"github.com/unixpickle/weakai/rnn"
+)
+
+func TestEligibility(t *testing.T) {
+	checkBlock(t, NewEligibilityLayer(3, 2, true))
+
+	block := NewEligibilityLayer(3, 2, false)
+	block.Dense.UseSTDPRule()
+	block.Dense.AddTraceBank(&HalfLifeRates{HalfLives: []float64{5}})
+	block.Dense.TraceClip = 0.5
+	checkBlock(t, block)
+}
+
+func TestEligibilityReward(t *testing.T) {
+	block := NewEligibilityLayer(3, 2, true)
+	traceSize := len(block.Dense.InitTrace.Vector)
+	state := block.StartState()
+	step := func(reward float64) linalg.Vector {
+		in := linalg.Vector{rand.NormFloat64(), rand.NormFloat64(), rand.NormFloat64(),
+			reward}
+		res := block.ApplyBlock([]rnn.State{state},
+			[]autofunc.Result{&autofunc.Variable{Vector: in}})
+		state = res.States()[0]
+		return linalg.Vector(state.(rnn.VecState))
+	}
+
+	for i := 0; i < 3; i++ {
+		vec := step(0)
+		if vec[:traceSize].MaxAbs() != 0 {
+			t.Fatal("trace changed without reward")
+		}
+		if vec[traceSize:].MaxAbs() == 0 {
+			t.Fatal("eligibility trace did not change")
+		}
+	}
+	if step(1)[:traceSize].MaxAbs() == 0 {
+		t.Error("trace did not change after reward")
+	}
+}
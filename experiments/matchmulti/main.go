@@ -8,6 +8,7 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/unixpickle/hebbnet/experiments"
 	"github.com/unixpickle/hebbnet/hebbdraw"
 	"github.com/unixpickle/seqtasks"
 )
@@ -15,17 +16,33 @@ import (
 const (
 	BatchSize  = 10
 	BatchCount = 20
+
+	// DefaultModel is used when no model name is given.
+	DefaultModel = "hebbvariable"
 )
 
 func main() {
-	if len(os.Args) != 2 {
-		fmt.Fprintln(os.Stderr, "Usage:", os.Args[0], "output_dir")
+	if len(os.Args) != 2 && len(os.Args) != 3 {
+		fmt.Fprintln(os.Stderr, "Usage:", os.Args[0], "[model_name] output_dir")
+		fmt.Fprintln(os.Stderr, "\nThe default model is", DefaultModel+".")
+		experiments.PrintModels()
+		fmt.Fprintln(os.Stderr)
+		os.Exit(1)
+	}
+
+	modelName := DefaultModel
+	if len(os.Args) == 3 {
+		modelName = os.Args[1]
+	}
+	creator, ok := experiments.Models[modelName]
+	if !ok {
+		fmt.Fprintln(os.Stderr, "Unknown model:", modelName)
 		os.Exit(1)
 	}
 
-	outDir := os.Args[1]
+	outDir := os.Args[len(os.Args)-1]
 
-	m := NewModel()
+	m := NewModel(creator)
 	task := &seqtasks.MatchMultiTask{
 		TypeCount: PunctuationCount,
 		MinLen:    1,
@@ -42,16 +59,25 @@ func main() {
 		}
 	}
 
+	if len(m.Layers) == 0 {
+		return
+	}
 	log.Println("Saving visualizations...")
 
 	for i, layer := range m.Layers {
-		names := []string{
-			fmt.Sprintf("layer%d_plasticities.png", i),
-			fmt.Sprintf("layer%d_trace_rates.png", i),
-		}
-		visualizations := []image.Image{
-			hebbdraw.VisualizePlasticities(layer),
-			hebbdraw.VisualizeTraceRates(layer),
+		var names []string
+		var visualizations []image.Image
+		plasticities := hebbdraw.VisualizeBankPlasticities(layer)
+		rates := hebbdraw.VisualizeBankRates(layer)
+		for bank := range plasticities {
+			var suffix string
+			if bank > 0 {
+				suffix = fmt.Sprintf("_bank%d", bank)
+			}
+			names = append(names,
+				fmt.Sprintf("layer%d_plasticities%s.png", i, suffix),
+				fmt.Sprintf("layer%d_trace_rates%s.png", i, suffix))
+			visualizations = append(visualizations, plasticities[bank], rates[bank])
 		}
 		for i, name := range names {
 			vis := visualizations[i]
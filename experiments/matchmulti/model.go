@@ -3,6 +3,7 @@ package main
 import (
 	"github.com/unixpickle/autofunc"
 	"github.com/unixpickle/hebbnet"
+	"github.com/unixpickle/hebbnet/experiments"
 	"github.com/unixpickle/num-analysis/linalg"
 	"github.com/unixpickle/sgd"
 	"github.com/unixpickle/weakai/neuralnet"
@@ -21,13 +22,17 @@ const (
 
 // A Model is a seqtasks.Model which uses a stacked block.
 type Model struct {
+	// Layers contains the blocks of Block which are
+	// DenseLayers, so that they can be visualized.
 	Layers []*hebbnet.DenseLayer
 	Block  rnn.StackedBlock
 
 	gradienter sgd.Gradienter
 }
 
-func NewModel() *Model {
+// NewModel creates a Model whose hidden layers are
+// created by the given experiments.Model.
+func NewModel(creator experiments.Model) *Model {
 	res := &Model{}
 	outNet := neuralnet.Network{
 		&neuralnet.LogSoftmaxLayer{},
@@ -35,7 +40,6 @@ func NewModel() *Model {
 	outNet.Randomize()
 	outBlock := rnn.NewNetworkBlock(outNet, 0)
 	for i := 0; i < LayerCount; i++ {
-		var layer *hebbnet.DenseLayer
 		inSize := InSize
 		if i > 0 {
 			inSize = HiddenSize
@@ -44,11 +48,11 @@ func NewModel() *Model {
 		if i+1 == LayerCount {
 			outSize = OutSize
 		}
-		layer = hebbnet.NewDenseLayer(inSize, outSize, true)
-		layer.UseActivation = true
-		layer.InitRates(0.1, 0.3)
+		layer := creator.CreateModel(inSize, outSize)
 		res.Block = append(res.Block, layer)
-		res.Layers = append(res.Layers, layer)
+		if dense, ok := layer.(*hebbnet.DenseLayer); ok {
+			res.Layers = append(res.Layers, dense)
+		}
 	}
 	res.Block = append(res.Block, outBlock)
 	return res
@@ -6,8 +6,10 @@ import (
 	"log"
 	"math/rand"
 	"os"
+	"strconv"
 	"time"
 
+	"github.com/unixpickle/hebbnet"
 	"github.com/unixpickle/hebbnet/experiments"
 	"github.com/unixpickle/mnist"
 	"github.com/unixpickle/weakai/neuralnet"
@@ -21,8 +23,8 @@ const (
 func main() {
 	rand.Seed(time.Now().UnixNano())
 
-	if len(os.Args) != 3 {
-		fmt.Fprintln(os.Stderr, "Usage:", os.Args[0], "model_name out_path")
+	if len(os.Args) != 3 && len(os.Args) != 4 {
+		fmt.Fprintln(os.Stderr, "Usage:", os.Args[0], "model_name out_path [truncation]")
 		experiments.PrintModels()
 		fmt.Fprintln(os.Stderr)
 		os.Exit(1)
@@ -34,6 +36,16 @@ func main() {
 		os.Exit(1)
 	}
 
+	var truncation int
+	if len(os.Args) == 4 {
+		var err error
+		truncation, err = strconv.Atoi(os.Args[3])
+		if err != nil || truncation < 0 {
+			fmt.Fprintln(os.Stderr, "Invalid truncation:", os.Args[3])
+			os.Exit(1)
+		}
+	}
+
 	var networkBlock rnn.StackedBlock
 	modelData, err := ioutil.ReadFile(os.Args[2])
 	if err == nil {
@@ -51,7 +63,7 @@ func main() {
 	training := SampleSet(mnist.LoadTrainingDataSet().Samples)
 	testing := SampleSet(mnist.LoadTestingDataSet().Samples)
 
-	TrainNetwork(networkBlock, training, testing)
+	TrainNetwork(networkBlock, training, testing, truncation)
 
 	data, err := networkBlock.Serialize()
 	if err != nil {
@@ -73,11 +85,17 @@ func createBlock(m experiments.Model) rnn.StackedBlock {
 		&neuralnet.LogSoftmaxLayer{},
 	}
 	outNet.Randomize()
-	return rnn.StackedBlock{
+	res := rnn.StackedBlock{
 		m.CreateModel(2, HiddenSize),
 		m.CreateModel(HiddenSize, HiddenSize),
 		rnn.NewNetworkBlock(outNet, 0),
 	}
+	for _, block := range res {
+		if layer, ok := block.(*hebbnet.DenseLayer); ok {
+			layer.Encoding = hebbnet.Float64Encoding
+		}
+	}
+	return res
 }
 
 func countParameters(b rnn.StackedBlock) int {
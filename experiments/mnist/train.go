@@ -3,6 +3,7 @@ package main
 import (
 	"log"
 
+	"github.com/unixpickle/hebbnet"
 	"github.com/unixpickle/sgd"
 	"github.com/unixpickle/weakai/neuralnet"
 	"github.com/unixpickle/weakai/rnn"
@@ -14,13 +15,24 @@ const (
 	BatchSize = 16
 )
 
-func TrainNetwork(net rnn.Block, training, testing sgd.SampleSet) {
+// TrainNetwork trains the network with full BPTT, or with
+// truncated BPTT if truncation is non-zero.
+func TrainNetwork(net rnn.Block, training, testing sgd.SampleSet, truncation int) {
+	var costGrad sgd.Gradienter = &seqtoseq.BPTT{
+		Block:    net,
+		Learner:  net.(sgd.Learner),
+		CostFunc: &neuralnet.DotCost{},
+	}
+	if truncation != 0 {
+		costGrad = &hebbnet.TruncatedBPTT{
+			Block:      net,
+			Learner:    net.(sgd.Learner),
+			CostFunc:   &neuralnet.DotCost{},
+			Truncation: truncation,
+		}
+	}
 	gradienter := &sgd.RMSProp{
-		Gradienter: &seqtoseq.BPTT{
-			Block:    net,
-			Learner:  net.(sgd.Learner),
-			CostFunc: &neuralnet.DotCost{},
-		},
+		Gradienter: costGrad,
 		Resiliency: 0.9,
 	}
 	var epoch int
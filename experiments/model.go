@@ -5,6 +5,7 @@ import (
 	"os"
 
 	"github.com/unixpickle/hebbnet"
+	"github.com/unixpickle/weakai/neuralnet"
 	"github.com/unixpickle/weakai/rnn"
 )
 
@@ -24,6 +25,59 @@ func (h *HebbModel) CreateModel(in, out int) rnn.Block {
 	return res
 }
 
+type ModulatedModel struct {
+	VariableRate bool
+	PerOutput    bool
+}
+
+func (m *ModulatedModel) CreateModel(in, out int) rnn.Block {
+	res := hebbnet.NewModulatedLayer(in, out, m.VariableRate, m.PerOutput)
+	res.Dense.UseActivation = true
+	res.Dense.InitRates(0.1, 0.3)
+	return res
+}
+
+type RecurrentHebbModel struct {
+	VariableRate bool
+}
+
+func (r *RecurrentHebbModel) CreateModel(in, out int) rnn.Block {
+	res := hebbnet.NewRecurrentDenseLayer(in, out, r.VariableRate)
+	res.Dense.InitRates(0.1, 0.3)
+	return res
+}
+
+type LowRankHebbModel struct {
+	Rank int
+}
+
+func (l *LowRankHebbModel) CreateModel(in, out int) rnn.Block {
+	res := hebbnet.NewLowRankLayer(in, out, l.Rank)
+	res.Activation = &neuralnet.HyperbolicTangent{}
+	return res
+}
+
+type FastWeightModel struct {
+	KeySize int
+}
+
+func (f *FastWeightModel) CreateModel(in, out int) rnn.Block {
+	res := hebbnet.NewFastWeightLayer(in, out, f.KeySize, false)
+	res.Memory.UseActivation = true
+	return res
+}
+
+type PlasticLSTMModel struct {
+	VariableRate bool
+}
+
+func (p *PlasticLSTMModel) CreateModel(in, out int) rnn.Block {
+	res := hebbnet.NewPlasticLSTM(in, out, p.VariableRate)
+	rates := &hebbnet.BucketRates{LongTerm: 0.1, ShortTerm: 0.3}
+	rates.InitRates(res.TraceRate.Vector)
+	return res
+}
+
 type LSTMModel struct{}
 
 func (l *LSTMModel) CreateModel(in, out int) rnn.Block {
@@ -36,11 +90,18 @@ func (l *NPRNNModel) CreateModel(in, out int) rnn.Block {
 	return rnn.NewNPRNN(in, out)
 }
 
-var ModelNames = []string{"hebbfixed", "hebbvariable", "lstm", "nprnn"}
+var ModelNames = []string{"hebbfixed", "hebbvariable", "hebbmod", "hebbmodrows", "hebbrnn",
+	"hebblowrank", "hebbmem", "hebblstm", "lstm", "nprnn"}
 
 var Models = map[string]Model{
 	"hebbfixed":    &HebbModel{UseActivation: true, VariableRate: false},
 	"hebbvariable": &HebbModel{UseActivation: true, VariableRate: true},
+	"hebbmod":      &ModulatedModel{VariableRate: true},
+	"hebbmodrows":  &ModulatedModel{VariableRate: true, PerOutput: true},
+	"hebbrnn":      &RecurrentHebbModel{VariableRate: true},
+	"hebblowrank":  &LowRankHebbModel{Rank: 16},
+	"hebbmem":      &FastWeightModel{KeySize: 32},
+	"hebblstm":     &PlasticLSTMModel{VariableRate: true},
 	"lstm":         &LSTMModel{},
 	"nprnn":        &NPRNNModel{},
 }
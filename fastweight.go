@@ -0,0 +1,186 @@
+package hebbnet
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+
+	"github.com/unixpickle/autofunc"
+	"github.com/unixpickle/num-analysis/linalg"
+	"github.com/unixpickle/serializer"
+	"github.com/unixpickle/weakai/neuralnet"
+	"github.com/unixpickle/weakai/rnn"
+)
+
+func init() {
+	var f FastWeightLayer
+	serializer.RegisterTypedDeserializer(f.SerializerType(), DeserializeFastWeightLayer)
+}
+
+// A FastWeightLayer is a key-value memory built on top of
+// a DenseLayer's Hebbian trace.
+//
+// At each timestep, the input is projected to a key, a
+// value, and a query (each squashed by tanh).
+// The trace of Memory is updated as if the key were the
+// input of Memory and the value were its output, so the
+// trace follows Memory's plasticity rule, trace rates,
+// mask, banks, and bounds.
+// Memory is then applied to the query using the updated
+// trace, and its output is the output of the layer.
+type FastWeightLayer struct {
+	InputCount int
+
+	// Memory maps queries to outputs, and its trace (an
+	// OutputCount by KeySize matrix) stores associations
+	// between keys and values.
+	Memory *DenseLayer
+
+	// KeyWeights and KeyBiases project inputs to keys.
+	KeyWeights *autofunc.Variable
+	KeyBiases  *autofunc.Variable
+
+	// ValueWeights and ValueBiases project inputs to
+	// values, which have OutputCount components.
+	ValueWeights *autofunc.Variable
+	ValueBiases  *autofunc.Variable
+
+	// QueryWeights and QueryBiases project inputs to
+	// queries, which are compared to keys.
+	QueryWeights *autofunc.Variable
+	QueryBiases  *autofunc.Variable
+}
+
+// DeserializeFastWeightLayer deserializes a
+// FastWeightLayer.
+func DeserializeFastWeightLayer(d []byte) (*FastWeightLayer, error) {
+	var res FastWeightLayer
+	if err := json.Unmarshal(d, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// NewFastWeightLayer creates a FastWeightLayer with
+// pre-initialized (semi-randomized) parameters.
+// The variableRate argument is passed to NewDenseLayer.
+func NewFastWeightLayer(inCount, outCount, keySize int, variableRate bool) *FastWeightLayer {
+	res := &FastWeightLayer{
+		InputCount:   inCount,
+		Memory:       NewDenseLayer(keySize, outCount, variableRate),
+		KeyWeights:   randomWeights(keySize, inCount),
+		KeyBiases:    &autofunc.Variable{Vector: make(linalg.Vector, keySize)},
+		ValueWeights: randomWeights(outCount, inCount),
+		ValueBiases:  &autofunc.Variable{Vector: make(linalg.Vector, outCount)},
+		QueryWeights: randomWeights(keySize, inCount),
+		QueryBiases:  &autofunc.Variable{Vector: make(linalg.Vector, keySize)},
+	}
+	for i := range res.Memory.Plasticities.Vector {
+		res.Memory.Plasticities.Vector[i] = 1
+	}
+	return res
+}
+
+// Parameters returns the layer's learnable parameters.
+func (f *FastWeightLayer) Parameters() []*autofunc.Variable {
+	return append(f.Memory.Parameters(),
+		f.KeyWeights,
+		f.KeyBiases,
+		f.ValueWeights,
+		f.ValueBiases,
+		f.QueryWeights,
+		f.QueryBiases,
+	)
+}
+
+// StartState returns the initial trace.
+func (f *FastWeightLayer) StartState() rnn.State {
+	return f.Memory.StartState()
+}
+
+// StartRState returns the initial trace.
+func (f *FastWeightLayer) StartRState(rv autofunc.RVector) rnn.RState {
+	return f.Memory.StartRState(rv)
+}
+
+// PropagateStart propagates through the start state.
+func (f *FastWeightLayer) PropagateStart(s []rnn.State, u []rnn.StateGrad,
+	g autofunc.Gradient) {
+	f.Memory.PropagateStart(s, u, g)
+}
+
+// PropagateStartR propagates through the start state.
+func (f *FastWeightLayer) PropagateStartR(s []rnn.RState, u []rnn.RStateGrad,
+	rg autofunc.RGradient, g autofunc.Gradient) {
+	f.Memory.PropagateStartR(s, u, rg, g)
+}
+
+// ApplyBlock applies the layer to a batch of inputs.
+func (f *FastWeightLayer) ApplyBlock(s []rnn.State, in []autofunc.Result) rnn.BlockResult {
+	return applyBlock(s, in, f.timestep)
+}
+
+// ApplyBlockR applies the layer to a batch of inputs.
+func (f *FastWeightLayer) ApplyBlockR(rv autofunc.RVector, s []rnn.RState,
+	in []autofunc.RResult) rnn.BlockRResult {
+	return applyBlockR(s, in, func(state, in autofunc.RResult) (newState, out autofunc.RResult) {
+		return f.timestepR(rv, state, in)
+	})
+}
+
+// SerializerType returns the unique ID used to serialize
+// this type with the serializer package.
+func (f *FastWeightLayer) SerializerType() string {
+	return "github.com/unixpickle/hebbnet.FastWeightLayer"
+}
+
+// Serialize serializes the layer.
+func (f *FastWeightLayer) Serialize() ([]byte, error) {
+	return json.Marshal(f)
+}
+
+func (f *FastWeightLayer) timestep(state, in autofunc.Result) (newState, out autofunc.Result) {
+	m := f.Memory
+	key := f.project(f.KeyWeights, f.KeyBiases, in)
+	value := f.project(f.ValueWeights, f.ValueBiases, in)
+	query := f.project(f.QueryWeights, f.QueryBiases, in)
+	newState = m.updateTrace(state, value, key, m.traceRates())
+	out, _ = m.output(newState, query)
+	return
+}
+
+func (f *FastWeightLayer) timestepR(rv autofunc.RVector, state,
+	in autofunc.RResult) (newState, out autofunc.RResult) {
+	m := f.Memory
+	key := f.projectR(rv, f.KeyWeights, f.KeyBiases, in)
+	value := f.projectR(rv, f.ValueWeights, f.ValueBiases, in)
+	query := f.projectR(rv, f.QueryWeights, f.QueryBiases, in)
+	newState = m.updateTraceR(rv, state, value, key, m.traceRatesR(rv))
+	out, _ = m.outputR(rv, newState, query)
+	return
+}
+
+func (f *FastWeightLayer) project(weights, biases *autofunc.Variable,
+	in autofunc.Result) autofunc.Result {
+	tran := autofunc.LinTran{Data: weights, Rows: len(biases.Vector), Cols: f.InputCount}
+	return neuralnet.HyperbolicTangent{}.Apply(autofunc.Add(biases, tran.Apply(in)))
+}
+
+func (f *FastWeightLayer) projectR(rv autofunc.RVector, weights, biases *autofunc.Variable,
+	in autofunc.RResult) autofunc.RResult {
+	tran := autofunc.LinTran{Data: weights, Rows: len(biases.Vector), Cols: f.InputCount}
+	return neuralnet.HyperbolicTangent{}.ApplyR(rv,
+		autofunc.AddR(autofunc.NewRVariable(biases, rv), tran.ApplyR(rv, in)))
+}
+
+// randomWeights creates a row-major weight matrix with
+// normally distributed entries scaled by the number of
+// columns.
+func randomWeights(rows, cols int) *autofunc.Variable {
+	res := &autofunc.Variable{Vector: make(linalg.Vector, rows*cols)}
+	stddev := 1 / math.Sqrt(float64(cols))
+	for i := range res.Vector {
+		res.Vector[i] = rand.NormFloat64() * stddev
+	}
+	return res
+}
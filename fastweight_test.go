@@ -0,0 +1,17 @@
+package hebbnet
+
+import "testing"
+
+func TestFastWeight(t *testing.T) {
+	block := NewFastWeightLayer(4, 3, 2, true)
+	block.Memory.UseActivation = true
+	checkBlock(t, block)
+}
+
+func TestFastWeightRules(t *testing.T) {
+	block := NewFastWeightLayer(4, 3, 2, true)
+	block.Memory.Rule = OjaRule
+	block.Memory.AddTraceBank(&HalfLifeRates{HalfLives: []float64{5}})
+	block.Memory.TraceClip = 0.5
+	checkBlock(t, block)
+}
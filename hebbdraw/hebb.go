@@ -7,26 +7,130 @@ import (
 	"github.com/unixpickle/num-analysis/linalg"
 )
 
+// VisualizePlasticities draws the plasticity matrix of a
+// layer.
+// Non-plastic connections are drawn in gray.
+//
+// For layers with more than one trace bank, this only
+// draws the first bank.
+// See VisualizeBankPlasticities.
 func VisualizePlasticities(h *hebbnet.DenseLayer) image.Image {
+	return visualizePlastic(h, h.EffectivePlasticities(0))
+}
+
+// VisualizeTraceRates draws the trace rates of a layer.
+// Layers with one rate per row are drawn as a column.
+// Otherwise, if the layer has more than one rate, the
+// rate of every connection is drawn, and non-plastic
+// connections are drawn in gray.
+//
+// For layers with more than one trace bank, this only
+// draws the first bank.
+// See VisualizeBankRates.
+func VisualizeTraceRates(h *hebbnet.DenseLayer) image.Image {
+	return visualizeRates(h, 0)
+}
+
+// VisualizeBankPlasticities draws the plasticity matrix
+// of every trace bank in a layer, with one panel per bank.
+func VisualizeBankPlasticities(h *hebbnet.DenseLayer) []image.Image {
+	var res []image.Image
+	for i := range h.TraceBanks() {
+		res = append(res, visualizePlastic(h, h.EffectivePlasticities(i)))
+	}
+	return res
+}
+
+// VisualizeBankRates draws the trace rates of every trace
+// bank in a layer, with one panel per bank.
+// Each panel is drawn like VisualizeTraceRates.
+func VisualizeBankRates(h *hebbnet.DenseLayer) []image.Image {
+	var res []image.Image
+	for i := range h.TraceBanks() {
+		res = append(res, visualizeRates(h, i))
+	}
+	return res
+}
+
+// VisualizeInputSigns draws the sign constraints of a
+// layer's inputs as a row, with excitatory inputs in red,
+// inhibitory inputs in blue, and unconstrained inputs in
+// gray.
+func VisualizeInputSigns(h *hebbnet.DenseLayer) image.Image {
+	data := make([]float64, h.InputCount)
+	mask := make([]bool, h.InputCount)
+	for i, sign := range h.InputSigns {
+		switch sign {
+		case hebbnet.Excitatory:
+			data[i], mask[i] = 1, true
+		case hebbnet.Inhibitory:
+			data[i], mask[i] = -1, true
+		}
+	}
+	return VisualizeMaskedMatrix(&linalg.Matrix{
+		Data: data,
+		Rows: 1,
+		Cols: h.InputCount,
+	}, mask)
+}
+
+// VisualizeEISplit draws the effective weight matrix of a
+// layer with its columns reordered so that excitatory
+// inputs come first, followed by unconstrained inputs and
+// then inhibitory inputs.
+// This makes the E/I structure of a sign-constrained
+// layer easy to see.
+func VisualizeEISplit(h *hebbnet.DenseLayer) image.Image {
+	var order []int
+	for _, group := range []hebbnet.InputSign{hebbnet.Excitatory, hebbnet.Unconstrained,
+		hebbnet.Inhibitory} {
+		for i := 0; i < h.InputCount; i++ {
+			sign := hebbnet.Unconstrained
+			if h.InputSigns != nil {
+				sign = h.InputSigns[i]
+			}
+			if sign == group {
+				order = append(order, i)
+			}
+		}
+	}
+	weights := h.EffectiveWeights()
+	data := make([]float64, len(weights))
+	for row := 0; row < h.OutputCount; row++ {
+		for col, src := range order {
+			data[row*h.InputCount+col] = weights[row*h.InputCount+src]
+		}
+	}
 	return VisualizeMatrix(&linalg.Matrix{
-		Data: h.Plasticities.Vector,
+		Data: data,
 		Rows: h.OutputCount,
 		Cols: h.InputCount,
 	})
 }
 
-func VisualizeTraceRates(h *hebbnet.DenseLayer) image.Image {
-	if len(h.TraceRate.Vector) == 1 {
+func visualizePlastic(h *hebbnet.DenseLayer, v linalg.Vector) image.Image {
+	return VisualizeMaskedMatrix(&linalg.Matrix{
+		Data: h.ExpandPlastic(v),
+		Rows: h.OutputCount,
+		Cols: h.InputCount,
+	}, h.PlasticMask())
+}
+
+func visualizeRates(h *hebbnet.DenseLayer, bank int) image.Image {
+	rates := h.TraceBanks()[bank].TraceRate.Vector
+	if len(rates) == 1 && h.RateGranularity == hebbnet.PerWeightRates {
 		return VisualizeMatrix(&linalg.Matrix{
-			Data: h.TraceRate.Vector,
+			Data: rates,
 			Rows: 1,
 			Cols: 1,
 		})
-	} else {
+	} else if h.RateGranularity == hebbnet.PerRowRates {
 		return VisualizeMatrix(&linalg.Matrix{
-			Data: h.TraceRate.Vector,
+			Data: rates,
 			Rows: h.OutputCount,
-			Cols: h.InputCount,
+			Cols: 1,
 		})
+	} else {
+		return visualizePlastic(h, h.PlasticBankRates(bank))
 	}
 }
@@ -16,6 +16,14 @@ const (
 )
 
 func VisualizeMatrix(m *linalg.Matrix) image.Image {
+	return VisualizeMaskedMatrix(m, nil)
+}
+
+// VisualizeMaskedMatrix is like VisualizeMatrix, but
+// entries for which mask is false are drawn in gray.
+// The mask is laid out like m.Data.
+// If mask is nil, every entry is drawn normally.
+func VisualizeMaskedMatrix(m *linalg.Matrix, mask []bool) image.Image {
 	maxAbs := linalg.Vector(m.Data).MaxAbs()
 
 	img := image.NewRGBA(image.Rect(0, 0, dotPadding*(m.Cols+1)+dotRadius*2*m.Cols,
@@ -29,7 +37,9 @@ func VisualizeMatrix(m *linalg.Matrix) image.Image {
 			if maxAbs > 0 {
 				val /= maxAbs
 			}
-			if val > 0 {
+			if mask != nil && !mask[i*m.Cols+j] {
+				gc.SetFillColor(color.Gray{Y: 0x80})
+			} else if val > 0 {
 				gc.SetFillColor(color.RGBA{R: uint8(val*0xff + 0.5), A: 0xff})
 			} else {
 				gc.SetFillColor(color.RGBA{B: uint8(-val*0xff + 0.5), A: 0xff})
@@ -0,0 +1,371 @@
+package hebbnet
+
+import (
+	"errors"
+	"math"
+
+	"github.com/unixpickle/autofunc"
+	"github.com/unixpickle/num-analysis/linalg"
+	"github.com/unixpickle/weakai/neuralnet"
+	"github.com/unixpickle/weakai/rnn"
+)
+
+// A DenseStepper runs a DenseLayer one timestep at a time
+// without using autofunc.
+// It is intended for inference, where gradients are not
+// needed.
+//
+// Stepping does not allocate any memory, provided that
+// the layer's activation function is nil, tanh, sigmoid,
+// or ReLU.
+// Other activation functions fall back on autofunc.
+//
+// A DenseStepper caches some functions of the layer's
+// parameters, so it must be re-created if the parameters
+// change.
+type DenseStepper struct {
+	Layer *DenseLayer
+
+	// Trace is the current Hebbian trace.
+	// If the layer has more than one trace bank, it
+	// contains the trace of every bank in order.
+	// It may be modified or replaced between steps.
+	Trace linalg.Vector
+
+	// Thresholds contains the current running thresholds
+	// of a layer using CovarianceRule, or nil if the layer
+	// has no running thresholds.
+	Thresholds linalg.Vector
+
+	// History contains the previous output followed by the
+	// previous input of a layer using STDPRule, or nil if
+	// the layer uses a different rule.
+	History linalg.Vector
+
+	weights        linalg.Vector
+	plasticities   []linalg.Vector
+	traceRates     []linalg.Vector
+	decayRates     linalg.Vector
+	thresholdRates linalg.Vector
+	output         linalg.Vector
+	preAct         linalg.Vector
+	rowNorms       linalg.Vector
+}
+
+// NewDenseStepper creates a DenseStepper which starts
+// with the layer's initial trace.
+func NewDenseStepper(d *DenseLayer) *DenseStepper {
+	res := &DenseStepper{
+		Layer:      d,
+		weights:    d.EffectiveWeights(),
+		decayRates: d.PlasticDecayRates(),
+		output:     make(linalg.Vector, d.OutputCount),
+		preAct:     make(linalg.Vector, d.OutputCount),
+		rowNorms:   make(linalg.Vector, d.OutputCount),
+	}
+	for i, b := range d.TraceBanks() {
+		res.Trace = append(res.Trace, b.InitTrace.Vector...)
+		res.plasticities = append(res.plasticities, d.EffectivePlasticities(i))
+		res.traceRates = append(res.traceRates, d.PlasticBankRates(i))
+	}
+	if d.runningThresholds() {
+		res.Thresholds = append(linalg.Vector{}, d.Thresholds.Vector...)
+		res.thresholdRates = append(linalg.Vector{}, d.ThresholdRate.Vector...)
+	} else if d.Rule == STDPRule {
+		res.History = d.initHistory().Vector
+	}
+	allRates := append([]linalg.Vector{res.decayRates, res.thresholdRates}, res.traceRates...)
+	for _, rates := range allRates {
+		for i, x := range rates {
+			rates[i] = 1 / (1 + math.Exp(-x))
+		}
+	}
+	return res
+}
+
+// Reset restores the layer's initial trace and rule
+// state.
+func (d *DenseStepper) Reset() {
+	traceSize := len(d.Layer.InitTrace.Vector)
+	for i, b := range d.Layer.TraceBanks() {
+		copy(d.Trace[i*traceSize:], b.InitTrace.Vector)
+	}
+	if d.Thresholds != nil {
+		copy(d.Thresholds, d.Layer.Thresholds.Vector)
+	}
+	for i := range d.History {
+		d.History[i] = 0
+	}
+}
+
+// Step runs the layer on an input and updates the trace.
+//
+// The returned vector is owned by the DenseStepper and
+// will be overwritten by the next call to Step.
+func (d *DenseStepper) Step(in linalg.Vector) linalg.Vector {
+	l := d.Layer
+	weights := d.weights
+	for row := range d.output {
+		d.output[row] = l.Biases.Vector[row] +
+			weights[row*l.InputCount:(row+1)*l.InputCount].Dot(in)
+	}
+	traceSize := len(l.InitTrace.Vector)
+	for i, plasticities := range d.plasticities {
+		trace := d.Trace[i*traceSize : (i+1)*traceSize]
+		d.forEachPlastic(func(k, row, col int) {
+			d.output[row] += plasticities[k] * trace[k] * in[col]
+		})
+	}
+
+	hebbOut := d.output
+	if act := l.activation(); act != nil {
+		if l.HebbPreActivation {
+			copy(d.preAct, d.output)
+			hebbOut = d.preAct
+		}
+		activateInPlace(act, d.output)
+	}
+
+	d.updateTrace(hebbOut, in)
+	if d.Thresholds != nil {
+		d.updateThresholds(hebbOut, in)
+	}
+	if d.History != nil {
+		copy(d.History, hebbOut)
+		copy(d.History[l.OutputCount:], in)
+	}
+	return d.output
+}
+
+func (d *DenseStepper) updateTrace(out, in linalg.Vector) {
+	traceSize := len(d.Layer.InitTrace.Vector)
+	for i, rates := range d.traceRates {
+		var decayRates linalg.Vector
+		if i == 0 {
+			decayRates = d.decayRates
+		}
+		d.updateBank(d.Trace[i*traceSize:(i+1)*traceSize], rates, decayRates, out, in)
+	}
+}
+
+// updateBank updates the trace of a single bank.
+// If decayRates is nil, the decay is tied to the rates.
+func (d *DenseStepper) updateBank(trace, traceRates, decayRates, out, in linalg.Vector) {
+	l := d.Layer
+	rate := traceRates[0]
+	singleRate := len(traceRates) == 1
+	var thresholds linalg.Vector
+	if l.Rule == CovarianceRule {
+		thresholds = d.Thresholds
+		if thresholds == nil {
+			thresholds = l.Thresholds.Vector
+		}
+	}
+	d.forEachPlastic(func(k, row, col int) {
+		if !singleRate {
+			rate = traceRates[k]
+		}
+		keep := 1 - rate
+		if decayRates != nil {
+			if singleRate {
+				keep = 1 - decayRates[0]
+			} else {
+				keep = 1 - decayRates[k]
+			}
+		}
+		y, x := out[row], in[col]
+		if thresholds != nil {
+			y -= thresholds[row]
+			x -= thresholds[l.OutputCount+col]
+		}
+		switch l.Rule {
+		case OjaRule:
+			change := rate * (y*x - y*y*trace[k])
+			if decayRates != nil {
+				trace[k] *= keep
+			}
+			trace[k] += change
+		case STDPRule:
+			coeffs := l.TimingCoeffs.Vector
+			hebb := y*x + coeffs[0]*y*d.History[l.OutputCount+col] + coeffs[1]*d.History[row]*x
+			trace[k] = keep*trace[k] + rate*hebb
+		default:
+			trace[k] = keep*trace[k] + rate*y*x
+		}
+		if l.TraceClip != 0 {
+			trace[k] = math.Max(-l.TraceClip, math.Min(l.TraceClip, trace[k]))
+		}
+	})
+	if l.TraceRowNorm == 0 {
+		return
+	}
+	for i := range d.rowNorms {
+		d.rowNorms[i] = 0
+	}
+	d.forEachPlastic(func(k, row, col int) {
+		d.rowNorms[row] += trace[k] * trace[k]
+	})
+	for i, x := range d.rowNorms {
+		d.rowNorms[i] = math.Sqrt(x)
+	}
+	d.forEachPlastic(func(k, row, col int) {
+		if norm := d.rowNorms[row]; norm > l.TraceRowNorm {
+			trace[k] *= l.TraceRowNorm / norm
+		}
+	})
+}
+
+func (d *DenseStepper) updateThresholds(out, in linalg.Vector) {
+	rate := d.thresholdRates[0]
+	for i := range d.Thresholds {
+		if len(d.thresholdRates) > 1 {
+			rate = d.thresholdRates[i]
+		}
+		var x float64
+		if i < len(out) {
+			x = out[i]
+		} else {
+			x = in[i-len(out)]
+		}
+		d.Thresholds[i] = (1-rate)*d.Thresholds[i] + rate*x
+	}
+}
+
+// forEachPlastic calls f for every plastic connection,
+// where k is the index of the connection in the trace.
+func (d *DenseStepper) forEachPlastic(f func(k, row, col int)) {
+	l := d.Layer
+	if l.PlasticIndices == nil {
+		for row := 0; row < l.OutputCount; row++ {
+			for col := 0; col < l.InputCount; col++ {
+				f(row*l.InputCount+col, row, col)
+			}
+		}
+	} else {
+		for k, idx := range l.PlasticIndices {
+			f(k, idx/l.InputCount, idx%l.InputCount)
+		}
+	}
+}
+
+// A StackedStepper runs a stack of DenseLayers followed
+// by an output network, one timestep at a time, without
+// using autofunc.
+//
+// The output network may contain neuralnet.DenseLayers,
+// neuralnet.LogSoftmaxLayers, and the activations
+// supported by DenseStepper without allocating memory.
+// Other layers fall back on autofunc.
+type StackedStepper struct {
+	Layers []*DenseStepper
+	OutNet neuralnet.Network
+
+	outBufs []linalg.Vector
+}
+
+// NewStackedStepper creates a StackedStepper for a
+// StackedBlock of DenseLayers and an output network.
+//
+// The output network is the network that would typically
+// be wrapped in an rnn.NetworkBlock at the end of the
+// block.
+// If the final block in b is not a DenseLayer, it is
+// assumed to be such a NetworkBlock and is ignored.
+func NewStackedStepper(b rnn.StackedBlock, outNet neuralnet.Network) (*StackedStepper, error) {
+	res := &StackedStepper{OutNet: outNet}
+	for i, block := range b {
+		layer, ok := block.(*DenseLayer)
+		if !ok {
+			if i+1 == len(b) {
+				break
+			}
+			return nil, errors.New("stacked block must contain DenseLayers")
+		}
+		res.Layers = append(res.Layers, NewDenseStepper(layer))
+	}
+	if len(res.Layers) == 0 {
+		return nil, errors.New("stacked block must contain DenseLayers")
+	}
+	for _, layer := range outNet {
+		var buf linalg.Vector
+		if dense, ok := layer.(*neuralnet.DenseLayer); ok {
+			buf = make(linalg.Vector, dense.OutputCount)
+		}
+		res.outBufs = append(res.outBufs, buf)
+	}
+	return res, nil
+}
+
+// Reset restores the initial traces of all the layers.
+func (s *StackedStepper) Reset() {
+	for _, l := range s.Layers {
+		l.Reset()
+	}
+}
+
+// Step runs the stack on an input and updates the traces.
+//
+// The returned vector may be owned by the StackedStepper,
+// in which case it will be overwritten by the next call
+// to Step.
+func (s *StackedStepper) Step(in linalg.Vector) linalg.Vector {
+	vec := in
+	for _, l := range s.Layers {
+		vec = l.Step(vec)
+	}
+	for i, layer := range s.OutNet {
+		switch layer := layer.(type) {
+		case *neuralnet.DenseLayer:
+			out := s.outBufs[i]
+			weights := layer.Weights.Data.Vector
+			for row := range out {
+				out[row] = layer.Biases.Var.Vector[row] +
+					weights[row*layer.InputCount:(row+1)*layer.InputCount].Dot(vec)
+			}
+			vec = out
+		case *neuralnet.LogSoftmaxLayer:
+			logSoftmaxInPlace(vec)
+		case Activation:
+			activateInPlace(layer, vec)
+		default:
+			vec = layer.Apply(&autofunc.Variable{Vector: vec}).Output()
+		}
+	}
+	return vec
+}
+
+// activateInPlace applies an activation function to a
+// vector in place.
+func activateInPlace(act autofunc.Func, vec linalg.Vector) {
+	switch act.(type) {
+	case neuralnet.HyperbolicTangent, *neuralnet.HyperbolicTangent:
+		for i, x := range vec {
+			vec[i] = math.Tanh(x)
+		}
+	case neuralnet.Sigmoid, *neuralnet.Sigmoid:
+		for i, x := range vec {
+			vec[i] = 1 / (1 + math.Exp(-x))
+		}
+	case neuralnet.ReLU, *neuralnet.ReLU:
+		for i, x := range vec {
+			vec[i] = math.Max(0, x)
+		}
+	default:
+		copy(vec, act.Apply(&autofunc.Variable{Vector: vec}).Output())
+	}
+}
+
+func logSoftmaxInPlace(vec linalg.Vector) {
+	max := math.Inf(-1)
+	for _, x := range vec {
+		max = math.Max(max, x)
+	}
+	var sum float64
+	for _, x := range vec {
+		sum += math.Exp(x - max)
+	}
+	logSum := max + math.Log(sum)
+	for i, x := range vec {
+		vec[i] = x - logSum
+	}
+}
@@ -0,0 +1,165 @@
+package hebbnet
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/unixpickle/autofunc"
+	"github.com/unixpickle/num-analysis/linalg"
+	"github.com/unixpickle/weakai/neuralnet"
+	"github.com/unixpickle/weakai/rnn"
+)
+
+func TestDenseStepper(t *testing.T) {
+	configs := map[string]func(d *DenseLayer){
+		"plain": func(d *DenseLayer) {},
+		"tanh": func(d *DenseLayer) {
+			d.UseActivation = true
+		},
+		"oja": func(d *DenseLayer) {
+			d.Rule = OjaRule
+			d.Activation = &neuralnet.Sigmoid{}
+			d.HebbPreActivation = true
+			d.TraceClip = 1
+		},
+		"bounded": func(d *DenseLayer) {
+			d.TraceClip = 0.3
+			d.TraceRowNorm = 0.5
+		},
+		"masked": func(d *DenseLayer) {
+			d.SetPlasticMask(RandomMask(d.InputCount, d.OutputCount, 0.5))
+			d.TraceRowNorm = 0.5
+		},
+		"decay": func(d *DenseLayer) {
+			d.Rule = OjaRule
+			d.UntieDecay()
+			for i := range d.DecayRate.Vector {
+				d.DecayRate.Vector[i] = rand.NormFloat64()
+			}
+			d.TraceClip = 1
+		},
+		"covariance": func(d *DenseLayer) {
+			d.UseCovarianceRule(false)
+			for i := range d.Thresholds.Vector {
+				d.Thresholds.Vector[i] = rand.NormFloat64()
+			}
+		},
+		"running": func(d *DenseLayer) {
+			d.UseCovarianceRule(true)
+			d.ThresholdRate.Vector = []float64{rand.NormFloat64(), rand.NormFloat64(),
+				rand.NormFloat64(), rand.NormFloat64(), rand.NormFloat64(),
+				rand.NormFloat64(), rand.NormFloat64(), rand.NormFloat64()}
+		},
+		"banks": func(d *DenseLayer) {
+			d.UntieDecay()
+			for i := 0; i < 2; i++ {
+				bank := d.AddTraceBank(&UniformRates{Min: 0.1, Max: 0.9})
+				for _, p := range []*autofunc.Variable{bank.Plasticities, bank.InitTrace} {
+					for i := range p.Vector {
+						p.Vector[i] = rand.NormFloat64()
+					}
+				}
+			}
+			d.SetPlasticMask(RandomMask(d.InputCount, d.OutputCount, 0.5))
+			d.TraceRowNorm = 0.5
+		},
+		"stdp": func(d *DenseLayer) {
+			d.UseSTDPRule()
+			d.AddTraceBank(&UniformRates{Min: 0.1, Max: 0.9})
+			d.Activation = &neuralnet.Sigmoid{}
+			d.HebbPreActivation = true
+		},
+		"signs": func(d *DenseLayer) {
+			d.SetPlasticMask(RandomMask(d.InputCount, d.OutputCount, 0.5))
+			d.ConstrainSigns([]InputSign{Excitatory, Inhibitory, Unconstrained, Inhibitory,
+				Excitatory})
+		},
+		"rowcol": func(d *DenseLayer) {
+			d.SetPlasticMask(RandomMask(d.InputCount, d.OutputCount, 0.5))
+		},
+	}
+	for name, config := range configs {
+		layer := NewDenseLayer(5, 3, true)
+		if name == "rowcol" {
+			layer = NewDenseLayerRates(5, 3, PerRowColumnRates)
+		}
+		for _, p := range layer.Parameters() {
+			for i := range p.Vector {
+				p.Vector[i] = rand.NormFloat64()
+			}
+		}
+		config(layer)
+
+		stepper := NewDenseStepper(layer)
+		state := layer.StartState()
+		for step := 0; step < 10; step++ {
+			in := make(linalg.Vector, layer.InputCount)
+			for i := range in {
+				in[i] = rand.NormFloat64()
+			}
+			res := layer.ApplyBlock([]rnn.State{state},
+				[]autofunc.Result{&autofunc.Variable{Vector: in}})
+			state = res.States()[0]
+			expected := res.Outputs()[0]
+			actual := stepper.Step(in)
+			if vecDiff(expected, actual) > 1e-8 {
+				t.Errorf("%s step %d: expected output %v but got %v", name, step,
+					expected, actual)
+			}
+			stepperState := joinVecs(stepper.Trace, stepper.Thresholds, stepper.History)
+			if vecDiff(linalg.Vector(state.(rnn.VecState)), stepperState) > 1e-8 {
+				t.Errorf("%s step %d: traces differ", name, step)
+			}
+		}
+	}
+}
+
+func TestDenseStepperAllocs(t *testing.T) {
+	layer := NewDenseLayer(10, 10, true)
+	layer.UseActivation = true
+	layer.TraceRowNorm = 1
+	stepper := NewDenseStepper(layer)
+	in := make(linalg.Vector, layer.InputCount)
+	allocs := testing.AllocsPerRun(100, func() {
+		stepper.Step(in)
+	})
+	if allocs != 0 {
+		t.Errorf("expected no allocations but got %f", allocs)
+	}
+}
+
+func TestStackedStepper(t *testing.T) {
+	outNet := neuralnet.Network{
+		&neuralnet.DenseLayer{InputCount: 4, OutputCount: 3},
+		&neuralnet.LogSoftmaxLayer{},
+	}
+	outNet.Randomize()
+	layer1 := NewDenseLayer(2, 4, true)
+	layer1.UseActivation = true
+	layer2 := NewDenseLayer(4, 4, false)
+	layer2.UseActivation = true
+	block := rnn.StackedBlock{layer1, layer2, rnn.NewNetworkBlock(outNet, 0)}
+
+	stepper, err := NewStackedStepper(block, outNet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runner := &rnn.Runner{Block: block}
+	for step := 0; step < 10; step++ {
+		in := linalg.Vector{rand.NormFloat64(), rand.NormFloat64()}
+		expected := runner.StepTime(in)
+		actual := stepper.Step(in)
+		if vecDiff(expected, actual) > 1e-8 {
+			t.Errorf("step %d: expected %v but got %v", step, expected, actual)
+		}
+	}
+}
+
+func vecDiff(v1, v2 linalg.Vector) float64 {
+	var res float64
+	for i, x := range v1 {
+		res = math.Max(res, math.Abs(x-v2[i]))
+	}
+	return res
+}
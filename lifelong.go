@@ -0,0 +1,124 @@
+package hebbnet
+
+import (
+	"github.com/unixpickle/num-analysis/linalg"
+	"github.com/unixpickle/weakai/rnn"
+)
+
+// A LifelongRunner runs a trained stacked model on an
+// unbounded stream of inputs.
+// The model keeps learning through its Hebbian traces,
+// which are never reset, while its parameters stay fixed.
+//
+// To keep the traces healthy over long streams, the
+// runner can periodically decay them or bound their norms.
+// These maintenance operations apply to the traces of
+// DenseLayers, ModulatedLayers, RecurrentDenseLayers, and
+// EligibilityLayers; other layers are left alone.
+type LifelongRunner struct {
+	Runner *StateRunner
+
+	// Interval is the number of steps between maintenance
+	// operations.
+	// If it is 0, no maintenance is performed.
+	Interval int
+
+	// Decay, if non-zero, is the fraction of every trace
+	// which is forgotten at each maintenance operation.
+	Decay float64
+
+	// MaxNorm, if non-zero, is the maximum L2 norm of each
+	// layer's trace after a maintenance operation.
+	// Traces with larger norms are scaled down.
+	MaxNorm float64
+
+	// Steps is the number of inputs processed so far.
+	Steps int
+}
+
+// NewLifelongRunner creates a LifelongRunner for the
+// block, starting from its start state.
+//
+// To resume from a saved state, create the runner with
+// RestoreRunner and set the Runner field directly.
+func NewLifelongRunner(block rnn.StackedBlock) *LifelongRunner {
+	return &LifelongRunner{Runner: &StateRunner{Block: block}}
+}
+
+// Step feeds the next input to the model and returns the
+// model's output.
+func (l *LifelongRunner) Step(in linalg.Vector) linalg.Vector {
+	out := l.Runner.StepTime(in)
+	l.Steps++
+	if l.Interval != 0 && l.Steps%l.Interval == 0 {
+		l.maintain()
+	}
+	return out
+}
+
+// TraceNorms returns the L2 norm of the current trace of
+// every layer in the block.
+// Layers without Hebbian traces have a norm of 0.
+func (l *LifelongRunner) TraceNorms() []float64 {
+	res := make([]float64, len(l.Runner.Block))
+	state := l.Runner.State
+	if state == nil {
+		state = l.Runner.Block.StartState().(rnn.StackedState)
+	}
+	for i, block := range l.Runner.Block {
+		if trace := layerTrace(block, state[i]); trace != nil {
+			res[i] = trace.Mag()
+		}
+	}
+	return res
+}
+
+func (l *LifelongRunner) maintain() {
+	if l.Decay == 0 && l.MaxNorm == 0 {
+		return
+	}
+	state := l.Runner.State
+	for i, block := range l.Runner.Block {
+		vecState, ok := state[i].(rnn.VecState)
+		if !ok || layerTrace(block, vecState) == nil {
+			continue
+		}
+		// Copy the state, since it may alias parameters
+		// such as InitTrace.
+		newState := append(rnn.VecState{}, vecState...)
+		trace := layerTrace(block, newState)
+		if l.Decay != 0 {
+			trace.Scale(1 - l.Decay)
+		}
+		if norm := trace.Mag(); l.MaxNorm != 0 && norm > l.MaxNorm {
+			trace.Scale(l.MaxNorm / norm)
+		}
+		state[i] = newState
+	}
+}
+
+// layerTrace returns the part of a layer's state which
+// stores its Hebbian traces, or nil if the layer is not a
+// supported Hebbian layer.
+// The result aliases the state.
+func layerTrace(block rnn.Block, state rnn.State) linalg.Vector {
+	var dense *DenseLayer
+	switch block := block.(type) {
+	case *DenseLayer:
+		dense = block
+	case *ModulatedLayer:
+		dense = block.Dense
+	case *RecurrentDenseLayer:
+		dense = block.Dense
+	case *EligibilityLayer:
+		dense = block.Dense
+	default:
+		return nil
+	}
+	vec, ok := state.(rnn.VecState)
+	if !ok {
+		return nil
+	}
+	traceSize := dense.traceSize()
+	return linalg.Vector(vec[:traceSize:traceSize])
+}
@@ -0,0 +1,114 @@
+package hebbnet
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/unixpickle/num-analysis/linalg"
+	"github.com/unixpickle/weakai/neuralnet"
+	"github.com/unixpickle/weakai/rnn"
+	"github.com/unixpickle/weakai/rnn/seqtoseq"
+)
+
+func TestLifelongAdaptation(t *testing.T) {
+	// The layer behaves like a trained associative memory:
+	// inputs are a key followed by a teacher signal, the
+	// teacher is copied to the output through fixed
+	// weights, and only the key connections are plastic.
+	const keySize = 4
+	const valueSize = 3
+	layer := NewDenseLayer(keySize+valueSize, valueSize, false)
+	mask := make([]bool, len(layer.Weights.Vector))
+	for row := 0; row < valueSize; row++ {
+		for col := 0; col < keySize+valueSize; col++ {
+			idx := row*(keySize+valueSize) + col
+			layer.Weights.Vector[idx] = 0
+			if col == keySize+row {
+				layer.Weights.Vector[idx] = 1
+			}
+			mask[idx] = col < keySize
+		}
+	}
+	layer.SetPlasticMask(mask)
+	for i := range layer.Plasticities.Vector {
+		layer.Plasticities.Vector[i] = 1
+	}
+	layer.TraceRate.Vector[0] = logit(0.2)
+
+	runner := NewLifelongRunner(rnn.StackedBlock{layer})
+	runner.Interval = 5
+	runner.Decay = 0.01
+	runner.MaxNorm = 10
+
+	key := linalg.Vector{0.5, -0.5, 0.5, 0.5}
+	value := linalg.Vector{1, -1, 0.5}
+	query := append(append(linalg.Vector{}, key...), make(linalg.Vector, valueSize)...)
+
+	if out := runner.Step(query); out.Mag() > 1e-8 {
+		t.Fatalf("unexpected recall before learning: %v", out)
+	}
+	if norms := runner.TraceNorms(); norms[0] != 0 {
+		t.Fatalf("unexpected trace norm before learning: %f", norms[0])
+	}
+
+	teach := append(append(linalg.Vector{}, key...), value...)
+	for i := 0; i < 10; i++ {
+		runner.Step(teach)
+	}
+	if runner.Steps != 11 {
+		t.Errorf("expected 11 steps but got %d", runner.Steps)
+	}
+	norms := runner.TraceNorms()
+	if norms[0] == 0 || norms[0] > runner.MaxNorm {
+		t.Errorf("unexpected trace norm after learning: %f", norms[0])
+	}
+
+	out := runner.Step(query)
+	cosine := out.Dot(value) / (out.Mag() * value.Mag())
+	if math.Abs(cosine-1) > 1e-5 {
+		t.Errorf("recalled %v for value %v (cosine %f)", out, value, cosine)
+	}
+}
+
+func TestLifelongTrained(t *testing.T) {
+	block := tbpttTestBlock()
+	layer := block[0].(*DenseLayer)
+	samples := tbpttTestSamples([]int{5, 4, 6})
+	gradienter := &seqtoseq.BPTT{
+		Block:    block,
+		Learner:  block,
+		CostFunc: &neuralnet.DotCost{},
+	}
+	initWeights := layer.Weights.Vector.Copy()
+	for i := 0; i < 5; i++ {
+		gradienter.Gradient(samples).AddToVars(-0.1)
+	}
+	if vecDiff(initWeights, layer.Weights.Vector) == 0 {
+		t.Fatal("training did not change the weights")
+	}
+
+	var params []linalg.Vector
+	for _, p := range block.Parameters() {
+		params = append(params, p.Vector.Copy())
+	}
+
+	runner := NewLifelongRunner(block)
+	runner.Interval = 3
+	runner.MaxNorm = 0.5
+	for i := 0; i < 30; i++ {
+		runner.Step(linalg.Vector{rand.NormFloat64(), rand.NormFloat64()})
+		if runner.Steps%runner.Interval != 0 {
+			continue
+		}
+		if norm := runner.TraceNorms()[0]; norm == 0 || norm > runner.MaxNorm+1e-8 {
+			t.Errorf("step %d: unexpected trace norm %f", runner.Steps, norm)
+		}
+	}
+
+	for i, p := range block.Parameters() {
+		if vecDiff(params[i], p.Vector) != 0 {
+			t.Errorf("parameter %d changed while running", i)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package hebbnet
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+
+	"github.com/unixpickle/autofunc"
+	"github.com/unixpickle/num-analysis/linalg"
+	"github.com/unixpickle/serializer"
+	"github.com/unixpickle/weakai/neuralnet"
+	"github.com/unixpickle/weakai/rnn"
+)
+
+func init() {
+	var l LowRankLayer
+	serializer.RegisterTypedDeserializer(l.SerializerType(), DeserializeLowRankLayer)
+}
+
+// A LowRankLayer is like a DenseLayer, except that the
+// Hebbian trace is stored as a sum of Rank rank-1 terms
+// rather than as a full matrix.
+//
+// The trace is a rolling window over the last Rank
+// timesteps: each timestep adds a new outer product of
+// the output and input, decays the other terms, and
+// drops the oldest term.
+// With a large enough Rank, this approximates the trace
+// of a DenseLayer with a single trace rate.
+//
+// The state of the layer stores Rank output factors,
+// followed by Rank input factors, newest first.
+// The output factors are pre-multiplied by the decay
+// accumulated so far.
+// Thus, the state takes Rank*(InputCount+OutputCount)
+// values instead of InputCount*OutputCount.
+type LowRankLayer struct {
+	InputCount  int
+	OutputCount int
+	Rank        int
+
+	// TraceRate stores a single value which is squashed
+	// between 0 and 1 to determine how quickly the trace
+	// changes, as in DenseLayer.
+	TraceRate *autofunc.Variable
+
+	// Weights stores the weight matrix of the layer in a
+	// row-major format.
+	Weights *autofunc.Variable
+
+	// Biases stores the output biases.
+	Biases *autofunc.Variable
+
+	// Plasticities is a matrix containing the plasticity
+	// for each connection.
+	// It is layed out like Weights in memory.
+	Plasticities *autofunc.Variable
+
+	// InitFactors is the initial state of the layer.
+	InitFactors *autofunc.Variable
+
+	// Activation and UseActivation have the same meaning
+	// as they do for DenseLayer.
+	Activation    Activation
+	UseActivation bool
+}
+
+// lowRankLayerJSON is the JSON representation of a
+// LowRankLayer.
+type lowRankLayerJSON struct {
+	*rawLowRankLayer
+
+	// Activation shadows LowRankLayer.Activation, storing
+	// it in serialized form.
+	Activation []byte `json:",omitempty"`
+}
+
+// rawLowRankLayer is a LowRankLayer without its JSON
+// methods.
+type rawLowRankLayer LowRankLayer
+
+// DeserializeLowRankLayer deserializes a LowRankLayer.
+func DeserializeLowRankLayer(d []byte) (*LowRankLayer, error) {
+	var res LowRankLayer
+	if err := json.Unmarshal(d, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// NewLowRankLayer creates a LowRankLayer with
+// pre-initialized (semi-randomized) parameters.
+//
+// It panics if rank is not positive.
+func NewLowRankLayer(inCount, outCount, rank int) *LowRankLayer {
+	if rank <= 0 {
+		panic(fmt.Sprintf("NewLowRankLayer: invalid rank %d", rank))
+	}
+	weightCount := inCount * outCount
+	res := &LowRankLayer{
+		InputCount:   inCount,
+		OutputCount:  outCount,
+		Rank:         rank,
+		TraceRate:    &autofunc.Variable{Vector: make(linalg.Vector, 1)},
+		Weights:      &autofunc.Variable{Vector: make(linalg.Vector, weightCount)},
+		Biases:       &autofunc.Variable{Vector: make(linalg.Vector, outCount)},
+		Plasticities: &autofunc.Variable{Vector: make(linalg.Vector, weightCount)},
+		InitFactors: &autofunc.Variable{
+			Vector: make(linalg.Vector, rank*(inCount+outCount)),
+		},
+	}
+	weightStddev := 1 / math.Sqrt(float64(inCount))
+	for i := 0; i < weightCount; i++ {
+		res.Weights.Vector[i] = rand.NormFloat64() * weightStddev
+	}
+	return res
+}
+
+// Parameters returns the layer's learnable parameters.
+func (l *LowRankLayer) Parameters() []*autofunc.Variable {
+	return []*autofunc.Variable{
+		l.TraceRate,
+		l.Weights,
+		l.Biases,
+		l.Plasticities,
+		l.InitFactors,
+	}
+}
+
+// StartState returns the initial trace factors.
+func (l *LowRankLayer) StartState() rnn.State {
+	return rnn.VecState(l.InitFactors.Vector)
+}
+
+// StartRState returns the initial trace factors.
+func (l *LowRankLayer) StartRState(rv autofunc.RVector) rnn.RState {
+	rvar := autofunc.NewRVariable(l.InitFactors, rv)
+	return rnn.VecRState{State: rvar.Output(), RState: rvar.ROutput()}
+}
+
+// PropagateStart propagates through the start state.
+func (l *LowRankLayer) PropagateStart(_ []rnn.State, s []rnn.StateGrad, g autofunc.Gradient) {
+	rnn.PropagateVarState(l.InitFactors, s, g)
+}
+
+// PropagateStartR propagates through the start state.
+func (l *LowRankLayer) PropagateStartR(_ []rnn.RState, s []rnn.RStateGrad,
+	rg autofunc.RGradient, g autofunc.Gradient) {
+	rnn.PropagateVarStateR(l.InitFactors, s, rg, g)
+}
+
+// ApplyBlock applies the layer to a batch of inputs.
+func (l *LowRankLayer) ApplyBlock(s []rnn.State, in []autofunc.Result) rnn.BlockResult {
+	return applyBlock(s, in, l.timestep)
+}
+
+// ApplyBlockR applies the layer to a batch of inputs.
+func (l *LowRankLayer) ApplyBlockR(rv autofunc.RVector, s []rnn.RState,
+	in []autofunc.RResult) rnn.BlockRResult {
+	return applyBlockR(s, in, func(state, in autofunc.RResult) (newState, out autofunc.RResult) {
+		return l.timestepR(rv, state, in)
+	})
+}
+
+// SerializerType returns the unique ID used to serialize
+// this type with the serializer package.
+func (l *LowRankLayer) SerializerType() string {
+	return "github.com/unixpickle/hebbnet.LowRankLayer"
+}
+
+// Serialize serializes the layer.
+func (l *LowRankLayer) Serialize() ([]byte, error) {
+	return json.Marshal(l)
+}
+
+// MarshalJSON encodes the layer as JSON, serializing the
+// activation function with the serializer package.
+func (l *LowRankLayer) MarshalJSON() ([]byte, error) {
+	obj := lowRankLayerJSON{rawLowRankLayer: (*rawLowRankLayer)(l)}
+	var err error
+	obj.Activation, err = serializeActivation(l.Activation)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&obj)
+}
+
+// UnmarshalJSON decodes the layer from JSON.
+// An error is returned if the layer's Rank is not
+// positive.
+func (l *LowRankLayer) UnmarshalJSON(data []byte) error {
+	obj := &lowRankLayerJSON{rawLowRankLayer: (*rawLowRankLayer)(l)}
+	if err := json.Unmarshal(data, obj); err != nil {
+		return err
+	}
+	var err error
+	l.Activation, err = deserializeActivation(obj.Activation)
+	if err != nil {
+		return err
+	}
+	if l.Rank <= 0 {
+		return fmt.Errorf("invalid LowRankLayer rank: %d", l.Rank)
+	}
+	return nil
+}
+
+// activation returns the activation function of the
+// layer, or nil if there is none.
+func (l *LowRankLayer) activation() Activation {
+	if l.Activation != nil {
+		return l.Activation
+	} else if l.UseActivation {
+		return &neuralnet.HyperbolicTangent{}
+	}
+	return nil
+}
+
+func (l *LowRankLayer) timestep(state, in autofunc.Result) (newState, out autofunc.Result) {
+	outFactorSize := l.Rank * l.OutputCount
+	weightTran := autofunc.LinTran{
+		Data: l.Weights,
+		Rows: l.OutputCount,
+		Cols: l.InputCount,
+	}
+	out = autofunc.Add(l.Biases, weightTran.Apply(in))
+
+	// (P o sum(u*v^T))*x = sum(u o (P*(v o x)))
+	for i := 0; i < l.Rank; i++ {
+		outFactor := autofunc.Slice(state, i*l.OutputCount, (i+1)*l.OutputCount)
+		inFactor := autofunc.Slice(state, outFactorSize+i*l.InputCount,
+			outFactorSize+(i+1)*l.InputCount)
+		plasticIn := autofunc.MatMulVec(l.Plasticities, l.OutputCount, l.InputCount,
+			autofunc.Mul(inFactor, in))
+		out = autofunc.Add(out, autofunc.Mul(outFactor, plasticIn))
+	}
+	if act := l.activation(); act != nil {
+		out = act.Apply(out)
+	}
+
+	traceRate := neuralnet.Sigmoid{}.Apply(l.TraceRate)
+	keepRate := autofunc.AddScaler(autofunc.Scale(traceRate, -1), 1)
+	oldOut := autofunc.Slice(state, 0, outFactorSize-l.OutputCount)
+	oldIn := autofunc.Slice(state, outFactorSize, len(state.Output())-l.InputCount)
+	newState = autofunc.Concat(
+		autofunc.ScaleFirst(out, traceRate),
+		autofunc.ScaleFirst(oldOut, keepRate),
+		in,
+		oldIn,
+	)
+	return
+}
+
+func (l *LowRankLayer) timestepR(rv autofunc.RVector, state,
+	in autofunc.RResult) (newState, out autofunc.RResult) {
+	outFactorSize := l.Rank * l.OutputCount
+	weightTran := autofunc.LinTran{
+		Data: l.Weights,
+		Rows: l.OutputCount,
+		Cols: l.InputCount,
+	}
+	out = autofunc.AddR(autofunc.NewRVariable(l.Biases, rv), weightTran.ApplyR(rv, in))
+
+	plasticities := autofunc.NewRVariable(l.Plasticities, rv)
+	for i := 0; i < l.Rank; i++ {
+		outFactor := autofunc.SliceR(state, i*l.OutputCount, (i+1)*l.OutputCount)
+		inFactor := autofunc.SliceR(state, outFactorSize+i*l.InputCount,
+			outFactorSize+(i+1)*l.InputCount)
+		plasticIn := autofunc.MatMulVecR(plasticities, l.OutputCount, l.InputCount,
+			autofunc.MulR(inFactor, in))
+		out = autofunc.AddR(out, autofunc.MulR(outFactor, plasticIn))
+	}
+	if act := l.activation(); act != nil {
+		out = act.ApplyR(rv, out)
+	}
+
+	traceRate := neuralnet.Sigmoid{}.ApplyR(rv, autofunc.NewRVariable(l.TraceRate, rv))
+	keepRate := autofunc.AddScalerR(autofunc.ScaleR(traceRate, -1), 1)
+	oldOut := autofunc.SliceR(state, 0, outFactorSize-l.OutputCount)
+	oldIn := autofunc.SliceR(state, outFactorSize, len(state.Output())-l.InputCount)
+	newState = autofunc.ConcatR(
+		autofunc.ScaleFirstR(out, traceRate),
+		autofunc.ScaleFirstR(oldOut, keepRate),
+		in,
+		oldIn,
+	)
+	return
+}
@@ -0,0 +1,50 @@
+package hebbnet
+
+import (
+	"testing"
+
+	"github.com/unixpickle/serializer"
+	"github.com/unixpickle/weakai/neuralnet"
+)
+
+func TestLowRank(t *testing.T) {
+	block := NewLowRankLayer(4, 2, 3)
+	block.Activation = &neuralnet.HyperbolicTangent{}
+	checkBlock(t, block)
+}
+
+func TestLowRankSerialize(t *testing.T) {
+	block := NewLowRankLayer(4, 2, 3)
+	block.Activation = &neuralnet.Sigmoid{}
+	data, err := serializer.SerializeWithType(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := serializer.DeserializeWithType(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := obj.(*LowRankLayer)
+	if _, ok := decoded.Activation.(*neuralnet.Sigmoid); !ok {
+		t.Errorf("unexpected activation: %T", decoded.Activation)
+	}
+
+	legacy := []byte(`{"InputCount":1,"OutputCount":1,"Rank":1,"UseActivation":true}`)
+	if layer, err := DeserializeLowRankLayer(legacy); err != nil {
+		t.Error(err)
+	} else if _, ok := layer.activation().(*neuralnet.HyperbolicTangent); !ok {
+		t.Errorf("unexpected legacy activation: %T", layer.activation())
+	}
+}
+
+func TestLowRankInvalidRank(t *testing.T) {
+	expectPanic(t, "zero rank", func() {
+		NewLowRankLayer(4, 2, 0)
+	})
+	block := NewLowRankLayer(4, 2, 1)
+	block.Rank = 0
+	data, _ := block.Serialize()
+	if _, err := DeserializeLowRankLayer(data); err == nil {
+		t.Error("expected error for zero rank")
+	}
+}
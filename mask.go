@@ -0,0 +1,295 @@
+package hebbnet
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/unixpickle/autofunc"
+	"github.com/unixpickle/num-analysis/linalg"
+)
+
+// RandomMask creates a plasticity mask for an outCount
+// by inCount weight matrix in which each connection is
+// independently plastic with probability density.
+func RandomMask(inCount, outCount int, density float64) []bool {
+	res := make([]bool, inCount*outCount)
+	for i := range res {
+		res[i] = rand.Float64() < density
+	}
+	return res
+}
+
+// BlockDiagonalMask creates a plasticity mask for an
+// outCount by inCount weight matrix in which only the
+// connections in blockCount diagonal blocks are plastic.
+//
+// The inputs and outputs are each split into blockCount
+// contiguous groups, and the connections from the i-th
+// input group to the i-th output group are plastic.
+func BlockDiagonalMask(inCount, outCount, blockCount int) []bool {
+	res := make([]bool, inCount*outCount)
+	for row := 0; row < outCount; row++ {
+		rowBlock := row * blockCount / outCount
+		for col := 0; col < inCount; col++ {
+			colBlock := col * blockCount / inCount
+			res[row*inCount+col] = rowBlock == colBlock
+		}
+	}
+	return res
+}
+
+// SetPlasticMask restricts plasticity to the connections
+// for which mask is true.
+// The mask is laid out like Weights in memory.
+//
+// Afterwards, Plasticities, InitTrace, and (if they have
+// one value per weight) TraceRate and DecayRate only store
+// values for the plastic connections.
+// The same goes for the vectors in Banks.
+// Per-row and per-column rates are left unchanged.
+// Existing values for those connections are preserved.
+//
+// If the layer already had a mask, the new mask should
+// be a subset of the old one.
+//
+// SetPlasticMask panics if the mask does not have one
+// entry per weight.
+func (d *DenseLayer) SetPlasticMask(mask []bool) {
+	if len(mask) != d.InputCount*d.OutputCount {
+		panic(fmt.Sprintf("SetPlasticMask: got %d entries for %d weights", len(mask),
+			d.InputCount*d.OutputCount))
+	}
+	oldMask := d.PlasticMask()
+	indices := []int{}
+	var oldIndices []int
+	var oldIdx int
+	for i, plastic := range mask {
+		if plastic && oldMask[i] {
+			indices = append(indices, i)
+			oldIndices = append(oldIndices, oldIdx)
+		}
+		if oldMask[i] {
+			oldIdx++
+		}
+	}
+	perWeight := d.RateGranularity == PerWeightRates && len(d.TraceRate.Vector) != 1
+	for _, b := range d.TraceBanks() {
+		b.Plasticities.Vector = gatherVec(b.Plasticities.Vector, oldIndices)
+		b.InitTrace.Vector = gatherVec(b.InitTrace.Vector, oldIndices)
+		if perWeight {
+			b.TraceRate.Vector = gatherVec(b.TraceRate.Vector, oldIndices)
+		}
+	}
+	if perWeight && d.DecayRate != nil {
+		d.DecayRate.Vector = gatherVec(d.DecayRate.Vector, oldIndices)
+	}
+	d.PlasticIndices = indices
+}
+
+// PlasticMask returns a mask, laid out like Weights, which
+// indicates which connections are plastic.
+func (d *DenseLayer) PlasticMask() []bool {
+	res := make([]bool, d.InputCount*d.OutputCount)
+	if d.PlasticIndices == nil {
+		for i := range res {
+			res[i] = true
+		}
+	} else {
+		for _, i := range d.PlasticIndices {
+			res[i] = true
+		}
+	}
+	return res
+}
+
+// ExpandPlastic converts a vector with one value per
+// plastic connection (such as Plasticities) into a full
+// matrix laid out like Weights.
+// Non-plastic connections are set to 0.
+func (d *DenseLayer) ExpandPlastic(v linalg.Vector) linalg.Vector {
+	if d.PlasticIndices == nil {
+		return append(linalg.Vector{}, v...)
+	}
+	res := make(linalg.Vector, d.InputCount*d.OutputCount)
+	for i, j := range d.PlasticIndices {
+		res[j] = v[i]
+	}
+	return res
+}
+
+// gatherPlastic extracts the plastic entries of a full
+// weight matrix.
+func (d *DenseLayer) gatherPlastic(m autofunc.Result) autofunc.Result {
+	if d.PlasticIndices == nil {
+		return m
+	}
+	return gather(m, d.PlasticIndices)
+}
+
+func (d *DenseLayer) gatherPlasticR(m autofunc.RResult) autofunc.RResult {
+	if d.PlasticIndices == nil {
+		return m
+	}
+	return gatherR(m, d.PlasticIndices)
+}
+
+// scatterPlastic is the inverse of gatherPlastic, filling
+// in non-plastic entries with zeros.
+func (d *DenseLayer) scatterPlastic(v autofunc.Result) autofunc.Result {
+	if d.PlasticIndices == nil {
+		return v
+	}
+	size := d.InputCount * d.OutputCount
+	return &scatterResult{
+		Input:   v,
+		Indices: d.PlasticIndices,
+		Result:  scatterVec(v.Output(), d.PlasticIndices, size),
+	}
+}
+
+func (d *DenseLayer) scatterPlasticR(v autofunc.RResult) autofunc.RResult {
+	if d.PlasticIndices == nil {
+		return v
+	}
+	size := d.InputCount * d.OutputCount
+	return &scatterRResult{
+		Input:   v,
+		Indices: d.PlasticIndices,
+		Result:  scatterVec(v.Output(), d.PlasticIndices, size),
+		RResult: scatterVec(v.ROutput(), d.PlasticIndices, size),
+	}
+}
+
+// gather creates a vector whose i-th component is the
+// indices[i]-th component of v.
+// Indices may be repeated.
+func gather(v autofunc.Result, indices []int) autofunc.Result {
+	return &gatherResult{
+		Input:   v,
+		Indices: indices,
+		Result:  gatherVec(v.Output(), indices),
+	}
+}
+
+func gatherR(v autofunc.RResult, indices []int) autofunc.RResult {
+	return &gatherRResult{
+		Input:   v,
+		Indices: indices,
+		Result:  gatherVec(v.Output(), indices),
+		RResult: gatherVec(v.ROutput(), indices),
+	}
+}
+
+type gatherResult struct {
+	Input   autofunc.Result
+	Indices []int
+	Result  linalg.Vector
+}
+
+func (g *gatherResult) Output() linalg.Vector {
+	return g.Result
+}
+
+func (g *gatherResult) Constant(grad autofunc.Gradient) bool {
+	return g.Input.Constant(grad)
+}
+
+func (g *gatherResult) PropagateGradient(upstream linalg.Vector, grad autofunc.Gradient) {
+	if !g.Input.Constant(grad) {
+		size := len(g.Input.Output())
+		g.Input.PropagateGradient(scatterVec(upstream, g.Indices, size), grad)
+	}
+}
+
+type gatherRResult struct {
+	Input   autofunc.RResult
+	Indices []int
+	Result  linalg.Vector
+	RResult linalg.Vector
+}
+
+func (g *gatherRResult) Output() linalg.Vector {
+	return g.Result
+}
+
+func (g *gatherRResult) ROutput() linalg.Vector {
+	return g.RResult
+}
+
+func (g *gatherRResult) Constant(rg autofunc.RGradient, grad autofunc.Gradient) bool {
+	return g.Input.Constant(rg, grad)
+}
+
+func (g *gatherRResult) PropagateRGradient(upstream, upstreamR linalg.Vector,
+	rg autofunc.RGradient, grad autofunc.Gradient) {
+	if !g.Input.Constant(rg, grad) {
+		size := len(g.Input.Output())
+		g.Input.PropagateRGradient(scatterVec(upstream, g.Indices, size),
+			scatterVec(upstreamR, g.Indices, size), rg, grad)
+	}
+}
+
+type scatterResult struct {
+	Input   autofunc.Result
+	Indices []int
+	Result  linalg.Vector
+}
+
+func (s *scatterResult) Output() linalg.Vector {
+	return s.Result
+}
+
+func (s *scatterResult) Constant(grad autofunc.Gradient) bool {
+	return s.Input.Constant(grad)
+}
+
+func (s *scatterResult) PropagateGradient(upstream linalg.Vector, grad autofunc.Gradient) {
+	if !s.Input.Constant(grad) {
+		s.Input.PropagateGradient(gatherVec(upstream, s.Indices), grad)
+	}
+}
+
+type scatterRResult struct {
+	Input   autofunc.RResult
+	Indices []int
+	Result  linalg.Vector
+	RResult linalg.Vector
+}
+
+func (s *scatterRResult) Output() linalg.Vector {
+	return s.Result
+}
+
+func (s *scatterRResult) ROutput() linalg.Vector {
+	return s.RResult
+}
+
+func (s *scatterRResult) Constant(rg autofunc.RGradient, grad autofunc.Gradient) bool {
+	return s.Input.Constant(rg, grad)
+}
+
+func (s *scatterRResult) PropagateRGradient(upstream, upstreamR linalg.Vector,
+	rg autofunc.RGradient, grad autofunc.Gradient) {
+	if !s.Input.Constant(rg, grad) {
+		s.Input.PropagateRGradient(gatherVec(upstream, s.Indices),
+			gatherVec(upstreamR, s.Indices), rg, grad)
+	}
+}
+
+func gatherVec(v linalg.Vector, indices []int) linalg.Vector {
+	res := make(linalg.Vector, len(indices))
+	for i, j := range indices {
+		res[i] = v[j]
+	}
+	return res
+}
+
+// scatterVec creates a vector of the given size and adds
+// the i-th component of v to the indices[i]-th component.
+func scatterVec(v linalg.Vector, indices []int, size int) linalg.Vector {
+	res := make(linalg.Vector, size)
+	for i, j := range indices {
+		res[j] += v[i]
+	}
+	return res
+}
@@ -0,0 +1,191 @@
+package hebbnet
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+
+	"github.com/unixpickle/autofunc"
+	"github.com/unixpickle/num-analysis/linalg"
+	"github.com/unixpickle/serializer"
+	"github.com/unixpickle/weakai/neuralnet"
+	"github.com/unixpickle/weakai/rnn"
+)
+
+func init() {
+	var m ModulatedLayer
+	serializer.RegisterTypedDeserializer(m.SerializerType(), DeserializeModulatedLayer)
+}
+
+// A ModulatedLayer is a DenseLayer whose Hebbian trace
+// updates are gated by a neuromodulation signal.
+//
+// At every timestep, the modulation signal is computed
+// from the layer's input and output by a learned affine
+// transformation followed by a sigmoid.
+// The signal multiplies the squashed trace rate of every
+// trace bank, so a modulation of 1 makes the layer behave
+// like its DenseLayer and a modulation of 0 freezes the
+// trace.
+// The exception is an untied DecayRate (see
+// DenseLayer.UntieDecay), which is not modulated, so the
+// first bank keeps decaying even while the modulation
+// is 0.
+type ModulatedLayer struct {
+	// Dense stores the weights, plasticities, and trace
+	// rates of the layer.
+	Dense *DenseLayer
+
+	// ModWeights stores the modulation weight matrix in a
+	// row-major format.
+	// There are InputCount+OutputCount columns (inputs
+	// first, then outputs) and one row per modulation
+	// signal.
+	ModWeights *autofunc.Variable
+
+	// ModBiases stores the modulation biases.
+	// There is either one bias (a single modulation signal
+	// for the whole trace) or one bias per output (one
+	// signal per row of the trace).
+	ModBiases *autofunc.Variable
+}
+
+// DeserializeModulatedLayer deserializes a ModulatedLayer.
+func DeserializeModulatedLayer(d []byte) (*ModulatedLayer, error) {
+	var res ModulatedLayer
+	if err := json.Unmarshal(d, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// NewModulatedLayer creates a ModulatedLayer with
+// pre-initialized (semi-randomized) parameters.
+// The variableRate argument is passed to NewDenseLayer.
+// If perOutput is true, a separate modulation signal is
+// used for each output of the layer.
+func NewModulatedLayer(inCount, outCount int, variableRate, perOutput bool) *ModulatedLayer {
+	modCount := 1
+	if perOutput {
+		modCount = outCount
+	}
+	res := &ModulatedLayer{
+		Dense:      NewDenseLayer(inCount, outCount, variableRate),
+		ModWeights: &autofunc.Variable{Vector: make(linalg.Vector, modCount*(inCount+outCount))},
+		ModBiases:  &autofunc.Variable{Vector: make(linalg.Vector, modCount)},
+	}
+	weightStddev := 1 / math.Sqrt(float64(inCount+outCount))
+	for i := range res.ModWeights.Vector {
+		res.ModWeights.Vector[i] = rand.NormFloat64() * weightStddev
+	}
+	return res
+}
+
+// Parameters returns the layer's learnable parameters.
+func (m *ModulatedLayer) Parameters() []*autofunc.Variable {
+	return append(m.Dense.Parameters(), m.ModWeights, m.ModBiases)
+}
+
+// StartState returns the initial trace.
+func (m *ModulatedLayer) StartState() rnn.State {
+	return m.Dense.StartState()
+}
+
+// StartRState returns the initial trace.
+func (m *ModulatedLayer) StartRState(rv autofunc.RVector) rnn.RState {
+	return m.Dense.StartRState(rv)
+}
+
+// PropagateStart propagates through the start state.
+func (m *ModulatedLayer) PropagateStart(s []rnn.State, u []rnn.StateGrad, g autofunc.Gradient) {
+	m.Dense.PropagateStart(s, u, g)
+}
+
+// PropagateStartR propagates through the start state.
+func (m *ModulatedLayer) PropagateStartR(s []rnn.RState, u []rnn.RStateGrad,
+	rg autofunc.RGradient, g autofunc.Gradient) {
+	m.Dense.PropagateStartR(s, u, rg, g)
+}
+
+// ApplyBlock applies the layer to a batch of inputs.
+func (m *ModulatedLayer) ApplyBlock(s []rnn.State, in []autofunc.Result) rnn.BlockResult {
+	return applyBlock(s, in, m.timestep)
+}
+
+// ApplyBlockR applies the layer to a batch of inputs.
+func (m *ModulatedLayer) ApplyBlockR(rv autofunc.RVector, s []rnn.RState,
+	in []autofunc.RResult) rnn.BlockRResult {
+	return applyBlockR(s, in, func(state, in autofunc.RResult) (newState, out autofunc.RResult) {
+		return m.timestepR(rv, state, in)
+	})
+}
+
+// SerializerType returns the unique ID used to serialize
+// this type with the serializer package.
+func (m *ModulatedLayer) SerializerType() string {
+	return "github.com/unixpickle/hebbnet.ModulatedLayer"
+}
+
+// Serialize serializes the layer.
+func (m *ModulatedLayer) Serialize() ([]byte, error) {
+	return json.Marshal(m)
+}
+
+func (m *ModulatedLayer) timestep(state, in autofunc.Result) (newState, out autofunc.Result) {
+	d := m.Dense
+	out, hebbOut := d.output(state, in)
+
+	modTran := autofunc.LinTran{
+		Data: m.ModWeights,
+		Rows: len(m.ModBiases.Vector),
+		Cols: d.InputCount + d.OutputCount,
+	}
+	modIn := autofunc.Concat(in, out)
+	modulation := neuralnet.Sigmoid{}.Apply(autofunc.Add(m.ModBiases, modTran.Apply(modIn)))
+
+	traceRates := d.traceRates()
+	var rowMod autofunc.Result
+	if len(modulation.Output()) != 1 {
+		rowMod = d.gatherPlastic(autofunc.OuterProduct(modulation, constOnes(d.InputCount)))
+	}
+	for i, traceRate := range traceRates {
+		if rowMod == nil {
+			traceRates[i] = autofunc.ScaleFirst(traceRate, modulation)
+		} else {
+			traceRates[i] = scaleByRate(rowMod, traceRate)
+		}
+	}
+	newState = d.updateTrace(state, hebbOut, in, traceRates)
+	return
+}
+
+func (m *ModulatedLayer) timestepR(rv autofunc.RVector, state,
+	in autofunc.RResult) (newState, out autofunc.RResult) {
+	d := m.Dense
+	out, hebbOut := d.outputR(rv, state, in)
+
+	modTran := autofunc.LinTran{
+		Data: m.ModWeights,
+		Rows: len(m.ModBiases.Vector),
+		Cols: d.InputCount + d.OutputCount,
+	}
+	modIn := autofunc.ConcatR(in, out)
+	modulation := neuralnet.Sigmoid{}.ApplyR(rv, autofunc.AddR(
+		autofunc.NewRVariable(m.ModBiases, rv), modTran.ApplyR(rv, modIn)))
+
+	traceRates := d.traceRatesR(rv)
+	var rowMod autofunc.RResult
+	if len(modulation.Output()) != 1 {
+		ones := autofunc.NewRVariable(constOnes(d.InputCount), rv)
+		rowMod = d.gatherPlasticR(autofunc.OuterProductR(modulation, ones))
+	}
+	for i, traceRate := range traceRates {
+		if rowMod == nil {
+			traceRates[i] = autofunc.ScaleFirstR(traceRate, modulation)
+		} else {
+			traceRates[i] = scaleByRateR(rowMod, traceRate)
+		}
+	}
+	newState = d.updateTraceR(rv, state, hebbOut, in, traceRates)
+	return
+}
@@ -0,0 +1,11 @@
+package hebbnet
+
+import "testing"
+
+func TestModulated(t *testing.T) {
+	checkBlock(t, NewModulatedLayer(4, 2, true, false))
+}
+
+func TestModulatedPerOutput(t *testing.T) {
+	checkBlock(t, NewModulatedLayer(4, 2, false, true))
+}
@@ -0,0 +1,245 @@
+package hebbnet
+
+import (
+	"encoding/json"
+
+	"github.com/unixpickle/autofunc"
+	"github.com/unixpickle/num-analysis/linalg"
+	"github.com/unixpickle/serializer"
+	"github.com/unixpickle/weakai/neuralnet"
+	"github.com/unixpickle/weakai/rnn"
+)
+
+func init() {
+	var p PlasticLSTM
+	serializer.RegisterTypedDeserializer(p.SerializerType(), DeserializePlasticLSTM)
+}
+
+// A PlasticLSTM is an LSTM whose cell input has Hebbian
+// plastic weights as well as standard weights.
+//
+// At each timestep, the gates and the cell input are
+// computed from the input concatenated with the previous
+// hidden state, as in a standard LSTM.
+// The cell input uses both fixed weights and plastic
+// weights (learned plasticities times a Hebbian trace),
+// so that both its input-to-cell and hidden-to-cell
+// connections are plastic.
+// The trace is then updated with the outer product of the
+// cell input and the concatenated inputs, using a learned
+// trace rate as in DenseLayer.
+//
+// The state of the layer is the Hebbian trace, followed
+// by the cell state and the hidden state, both of which
+// start at 0.
+type PlasticLSTM struct {
+	InputCount int
+	HiddenSize int
+
+	// Each gate and the cell input has a weight matrix and
+	// a bias vector.
+	// The weight matrices are row-major, with HiddenSize
+	// rows and InputCount+HiddenSize columns (inputs first,
+	// then the previous hidden state).
+	InputWeights  *autofunc.Variable
+	InputBiases   *autofunc.Variable
+	ForgetWeights *autofunc.Variable
+	ForgetBiases  *autofunc.Variable
+	OutputWeights *autofunc.Variable
+	OutputBiases  *autofunc.Variable
+	CellWeights   *autofunc.Variable
+	CellBiases    *autofunc.Variable
+
+	// TraceRate, Plasticities, and InitTrace are used like
+	// the corresponding fields of DenseLayer, where the
+	// trace is laid out like CellWeights.
+	TraceRate    *autofunc.Variable
+	Plasticities *autofunc.Variable
+	InitTrace    *autofunc.Variable
+}
+
+// DeserializePlasticLSTM deserializes a PlasticLSTM.
+func DeserializePlasticLSTM(d []byte) (*PlasticLSTM, error) {
+	var res PlasticLSTM
+	if err := json.Unmarshal(d, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// NewPlasticLSTM creates a PlasticLSTM with
+// pre-initialized (semi-randomized) parameters.
+// If variableRate is true, a different trace rate is used
+// for each plastic connection.
+//
+// The forget gate biases start at 1, so that the cell
+// state is retained by default.
+func NewPlasticLSTM(inCount, hiddenSize int, variableRate bool) *PlasticLSTM {
+	colCount := inCount + hiddenSize
+	traceSize := hiddenSize * colCount
+	traceCount := 1
+	if variableRate {
+		traceCount = traceSize
+	}
+	res := &PlasticLSTM{
+		InputCount:    inCount,
+		HiddenSize:    hiddenSize,
+		InputWeights:  randomWeights(hiddenSize, colCount),
+		InputBiases:   &autofunc.Variable{Vector: make(linalg.Vector, hiddenSize)},
+		ForgetWeights: randomWeights(hiddenSize, colCount),
+		ForgetBiases:  &autofunc.Variable{Vector: make(linalg.Vector, hiddenSize)},
+		OutputWeights: randomWeights(hiddenSize, colCount),
+		OutputBiases:  &autofunc.Variable{Vector: make(linalg.Vector, hiddenSize)},
+		CellWeights:   randomWeights(hiddenSize, colCount),
+		CellBiases:    &autofunc.Variable{Vector: make(linalg.Vector, hiddenSize)},
+		TraceRate:     &autofunc.Variable{Vector: make(linalg.Vector, traceCount)},
+		Plasticities:  &autofunc.Variable{Vector: make(linalg.Vector, traceSize)},
+		InitTrace:     &autofunc.Variable{Vector: make(linalg.Vector, traceSize)},
+	}
+	for i := range res.ForgetBiases.Vector {
+		res.ForgetBiases.Vector[i] = 1
+	}
+	return res
+}
+
+// Parameters returns the layer's learnable parameters.
+func (p *PlasticLSTM) Parameters() []*autofunc.Variable {
+	return []*autofunc.Variable{
+		p.InputWeights,
+		p.InputBiases,
+		p.ForgetWeights,
+		p.ForgetBiases,
+		p.OutputWeights,
+		p.OutputBiases,
+		p.CellWeights,
+		p.CellBiases,
+		p.TraceRate,
+		p.Plasticities,
+		p.InitTrace,
+	}
+}
+
+// StartState returns the initial trace followed by empty
+// cell and hidden states.
+func (p *PlasticLSTM) StartState() rnn.State {
+	return startVecState(p.startVars())
+}
+
+// StartRState returns the initial trace followed by empty
+// cell and hidden states.
+func (p *PlasticLSTM) StartRState(rv autofunc.RVector) rnn.RState {
+	return startVecRState(rv, p.startVars())
+}
+
+// PropagateStart propagates through the start state.
+func (p *PlasticLSTM) PropagateStart(_ []rnn.State, s []rnn.StateGrad, g autofunc.Gradient) {
+	propagateStartVars(p.startVars(), s, g)
+}
+
+// PropagateStartR propagates through the start state.
+func (p *PlasticLSTM) PropagateStartR(_ []rnn.RState, s []rnn.RStateGrad,
+	rg autofunc.RGradient, g autofunc.Gradient) {
+	propagateStartVarsR(p.startVars(), s, rg, g)
+}
+
+// ApplyBlock applies the layer to a batch of inputs.
+func (p *PlasticLSTM) ApplyBlock(s []rnn.State, in []autofunc.Result) rnn.BlockResult {
+	return applyBlock(s, in, p.timestep)
+}
+
+// ApplyBlockR applies the layer to a batch of inputs.
+func (p *PlasticLSTM) ApplyBlockR(rv autofunc.RVector, s []rnn.RState,
+	in []autofunc.RResult) rnn.BlockRResult {
+	return applyBlockR(s, in, func(state, in autofunc.RResult) (newState, out autofunc.RResult) {
+		return p.timestepR(rv, state, in)
+	})
+}
+
+// SerializerType returns the unique ID used to serialize
+// this type with the serializer package.
+func (p *PlasticLSTM) SerializerType() string {
+	return "github.com/unixpickle/hebbnet.PlasticLSTM"
+}
+
+// Serialize serializes the layer.
+func (p *PlasticLSTM) Serialize() ([]byte, error) {
+	return json.Marshal(p)
+}
+
+func (p *PlasticLSTM) startVars() []*autofunc.Variable {
+	memory := &autofunc.Variable{Vector: make(linalg.Vector, p.HiddenSize*2)}
+	return []*autofunc.Variable{p.InitTrace, memory}
+}
+
+func (p *PlasticLSTM) timestep(state, in autofunc.Result) (newState, out autofunc.Result) {
+	traceSize := len(p.InitTrace.Vector)
+	trace := autofunc.Slice(state, 0, traceSize)
+	cell := autofunc.Slice(state, traceSize, traceSize+p.HiddenSize)
+	hidden := autofunc.Slice(state, traceSize+p.HiddenSize, len(state.Output()))
+	fullIn := autofunc.Concat(in, hidden)
+	colCount := p.InputCount + p.HiddenSize
+
+	inGate := neuralnet.Sigmoid{}.Apply(p.affine(p.InputWeights, p.InputBiases, fullIn))
+	forgetGate := neuralnet.Sigmoid{}.Apply(p.affine(p.ForgetWeights, p.ForgetBiases, fullIn))
+	outGate := neuralnet.Sigmoid{}.Apply(p.affine(p.OutputWeights, p.OutputBiases, fullIn))
+
+	plastic := autofunc.MatMulVec(autofunc.Mul(p.Plasticities, trace), p.HiddenSize, colCount,
+		fullIn)
+	cellIn := neuralnet.HyperbolicTangent{}.Apply(autofunc.Add(
+		p.affine(p.CellWeights, p.CellBiases, fullIn), plastic))
+
+	traceRate := neuralnet.Sigmoid{}.Apply(p.TraceRate)
+	keepRate := autofunc.AddScaler(autofunc.Scale(traceRate, -1), 1)
+	newTrace := autofunc.Add(scaleByRate(trace, keepRate),
+		scaleByRate(autofunc.OuterProduct(cellIn, fullIn), traceRate))
+
+	newCell := autofunc.Add(autofunc.Mul(forgetGate, cell), autofunc.Mul(inGate, cellIn))
+	out = autofunc.Mul(outGate, neuralnet.HyperbolicTangent{}.Apply(newCell))
+	newState = autofunc.Concat(newTrace, newCell, out)
+	return
+}
+
+func (p *PlasticLSTM) timestepR(rv autofunc.RVector, state,
+	in autofunc.RResult) (newState, out autofunc.RResult) {
+	traceSize := len(p.InitTrace.Vector)
+	trace := autofunc.SliceR(state, 0, traceSize)
+	cell := autofunc.SliceR(state, traceSize, traceSize+p.HiddenSize)
+	hidden := autofunc.SliceR(state, traceSize+p.HiddenSize, len(state.Output()))
+	fullIn := autofunc.ConcatR(in, hidden)
+	colCount := p.InputCount + p.HiddenSize
+
+	inGate := neuralnet.Sigmoid{}.ApplyR(rv,
+		p.affineR(rv, p.InputWeights, p.InputBiases, fullIn))
+	forgetGate := neuralnet.Sigmoid{}.ApplyR(rv,
+		p.affineR(rv, p.ForgetWeights, p.ForgetBiases, fullIn))
+	outGate := neuralnet.Sigmoid{}.ApplyR(rv,
+		p.affineR(rv, p.OutputWeights, p.OutputBiases, fullIn))
+
+	plasticities := autofunc.NewRVariable(p.Plasticities, rv)
+	plastic := autofunc.MatMulVecR(autofunc.MulR(plasticities, trace), p.HiddenSize, colCount,
+		fullIn)
+	cellIn := neuralnet.HyperbolicTangent{}.ApplyR(rv, autofunc.AddR(
+		p.affineR(rv, p.CellWeights, p.CellBiases, fullIn), plastic))
+
+	traceRate := neuralnet.Sigmoid{}.ApplyR(rv, autofunc.NewRVariable(p.TraceRate, rv))
+	keepRate := autofunc.AddScalerR(autofunc.ScaleR(traceRate, -1), 1)
+	newTrace := autofunc.AddR(scaleByRateR(trace, keepRate),
+		scaleByRateR(autofunc.OuterProductR(cellIn, fullIn), traceRate))
+
+	newCell := autofunc.AddR(autofunc.MulR(forgetGate, cell), autofunc.MulR(inGate, cellIn))
+	out = autofunc.MulR(outGate, neuralnet.HyperbolicTangent{}.ApplyR(rv, newCell))
+	newState = autofunc.ConcatR(newTrace, newCell, out)
+	return
+}
+
+func (p *PlasticLSTM) affine(weights, biases *autofunc.Variable,
+	in autofunc.Result) autofunc.Result {
+	tran := autofunc.LinTran{Data: weights, Rows: p.HiddenSize, Cols: p.InputCount + p.HiddenSize}
+	return autofunc.Add(biases, tran.Apply(in))
+}
+
+func (p *PlasticLSTM) affineR(rv autofunc.RVector, weights, biases *autofunc.Variable,
+	in autofunc.RResult) autofunc.RResult {
+	tran := autofunc.LinTran{Data: weights, Rows: p.HiddenSize, Cols: p.InputCount + p.HiddenSize}
+	return autofunc.AddR(autofunc.NewRVariable(biases, rv), tran.ApplyR(rv, in))
+}
@@ -0,0 +1,7 @@
+package hebbnet
+
+import "testing"
+
+func TestPlasticLSTM(t *testing.T) {
+	checkBlock(t, NewPlasticLSTM(4, 2, true))
+}
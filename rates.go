@@ -0,0 +1,166 @@
+package hebbnet
+
+import (
+	"math"
+	"math/rand"
+
+	"github.com/unixpickle/num-analysis/linalg"
+)
+
+// A RateInitializer initializes trace rates, such as the
+// ones in DenseLayer.TraceRate.
+//
+// Trace rates are stored before they are squashed by a
+// sigmoid, so initializers produce the logits of the
+// rates they wish to use.
+type RateInitializer interface {
+	InitRates(rates linalg.Vector)
+}
+
+// PlasticRates returns the unsquashed trace rate of every
+// plastic connection in the layer, laid out like
+// Plasticities.
+// If the layer has a single shared rate, the result has
+// only one value.
+func (d *DenseLayer) PlasticRates() linalg.Vector {
+	return d.expandRates(d.TraceRate.Vector)
+}
+
+// PlasticBankRates is like PlasticRates, but for the
+// trace bank with the given index in TraceBanks.
+func (d *DenseLayer) PlasticBankRates(bank int) linalg.Vector {
+	return d.expandRates(d.TraceBanks()[bank].TraceRate.Vector)
+}
+
+// PlasticDecayRates is like PlasticRates, but for the
+// layer's DecayRate.
+// It returns nil if the layer has no DecayRate.
+func (d *DenseLayer) PlasticDecayRates() linalg.Vector {
+	if d.DecayRate == nil {
+		return nil
+	}
+	return d.expandRates(d.DecayRate.Vector)
+}
+
+func (d *DenseLayer) expandRates(rates linalg.Vector) linalg.Vector {
+	if d.RateGranularity == PerWeightRates {
+		return append(linalg.Vector{}, rates...)
+	}
+	rows, cols := d.plasticCoords()
+	res := make(linalg.Vector, len(rows))
+	for i, row := range rows {
+		res[i] = rates[row]
+		if d.RateGranularity == PerRowColumnRates {
+			res[i] += rates[cols[i]]
+		}
+	}
+	return res
+}
+
+// UniformRates initializes squashed trace rates uniformly
+// at random between Min and Max.
+// Both bounds should be strictly between 0 and 1.
+type UniformRates struct {
+	Min float64
+	Max float64
+}
+
+// InitRates initializes the rates.
+func (u *UniformRates) InitRates(rates linalg.Vector) {
+	for i := range rates {
+		rates[i] = logit(u.Min + rand.Float64()*(u.Max-u.Min))
+	}
+}
+
+// LogUniformRates initializes trace rates so that their
+// half-lives, measured in timesteps, are distributed
+// log-uniformly between MinHalfLife and MaxHalfLife.
+//
+// This is useful for covering many timescales at once,
+// since a uniform distribution over rates would make most
+// traces short-lived.
+type LogUniformRates struct {
+	MinHalfLife float64
+	MaxHalfLife float64
+}
+
+// InitRates initializes the rates.
+func (l *LogUniformRates) InitRates(rates linalg.Vector) {
+	logMin := math.Log(l.MinHalfLife)
+	logMax := math.Log(l.MaxHalfLife)
+	for i := range rates {
+		halfLife := math.Exp(logMin + rand.Float64()*(logMax-logMin))
+		rates[i] = halfLifeLogit(halfLife)
+	}
+}
+
+// HalfLifeRates initializes trace rates to exactly match
+// a list of half-lives, measured in timesteps.
+//
+// The half-lives are spread as evenly as possible across
+// the rates, with each half-life being assigned to a
+// random subset of the rates.
+type HalfLifeRates struct {
+	HalfLives []float64
+}
+
+// InitRates initializes the rates.
+// It panics if HalfLives is empty.
+func (h *HalfLifeRates) InitRates(rates linalg.Vector) {
+	if len(h.HalfLives) == 0 {
+		panic("HalfLifeRates: no half-lives to initialize rates with")
+	}
+	for i, j := range rand.Perm(len(rates)) {
+		rates[j] = halfLifeLogit(h.HalfLives[i%len(h.HalfLives)])
+	}
+}
+
+// BucketRates initializes trace rates by dividing them up
+// into three sections: long-term, short-term, and neutral.
+//
+// LongTerm and ShortTerm specify the fraction of rates in
+// the long-term and short-term sections.
+// If these fractions add up to more than 1, the short-term
+// fraction is reduced first.
+//
+// Long-term rates are squashed to roughly 0.12-0.27,
+// short-term rates to 0.88-0.95, and neutral rates to 0.5.
+type BucketRates struct {
+	LongTerm  float64
+	ShortTerm float64
+}
+
+// InitRates initializes the rates.
+func (b *BucketRates) InitRates(rates linalg.Vector) {
+	indices := rand.Perm(len(rates))
+	lt := int(math.Ceil(b.LongTerm * float64(len(indices))))
+	st := int(math.Ceil(b.ShortTerm * float64(len(indices))))
+	for lt+st > len(indices) {
+		if st > 0 {
+			st--
+		} else {
+			lt--
+		}
+	}
+	for _, i := range indices[:lt] {
+		rates[i] = rand.Float64() - 2
+	}
+	for _, i := range indices[lt : lt+st] {
+		rates[i] = rand.Float64() + 2
+	}
+	for _, i := range indices[lt+st:] {
+		rates[i] = 0
+	}
+}
+
+// logit is the inverse of the sigmoid function.
+func logit(p float64) float64 {
+	return math.Log(p / (1 - p))
+}
+
+// halfLifeLogit computes the unsquashed trace rate which
+// makes a trace decay by half after halfLife timesteps.
+func halfLifeLogit(halfLife float64) float64 {
+	keepRate := math.Pow(0.5, 1/halfLife)
+	return logit(1 - keepRate)
+}
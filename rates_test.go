@@ -0,0 +1,97 @@
+package hebbnet
+
+import (
+	"math"
+	"testing"
+
+	"github.com/unixpickle/num-analysis/linalg"
+)
+
+func TestBucketRates(t *testing.T) {
+	fractions := [][2]float64{{0.1, 0.3}, {0.3, 0.1}, {0.5, 0.5}, {0.7, 0.7}, {0, 0.2}}
+	for _, frac := range fractions {
+		rates := make(linalg.Vector, 100)
+		(&BucketRates{LongTerm: frac[0], ShortTerm: frac[1]}).InitRates(rates)
+		var long, short, neutral int
+		for _, x := range squashRates(rates) {
+			if x > 0.1 && x < 0.3 {
+				long++
+			} else if x > 0.85 && x < 0.96 {
+				short++
+			} else if x == 0.5 {
+				neutral++
+			} else {
+				t.Errorf("fractions %v: unexpected rate %f", frac, x)
+			}
+		}
+		expLong := int(math.Ceil(frac[0] * 100))
+		expShort := int(math.Ceil(frac[1] * 100))
+		if expLong+expShort > 100 {
+			expShort = 100 - expLong
+		}
+		if long != expLong || short != expShort || neutral != 100-expLong-expShort {
+			t.Errorf("fractions %v: got %d/%d/%d long/short/neutral", frac, long, short,
+				neutral)
+		}
+	}
+}
+
+func TestUniformRates(t *testing.T) {
+	rates := make(linalg.Vector, 10000)
+	(&UniformRates{Min: 0.2, Max: 0.6}).InitRates(rates)
+	var sum float64
+	for _, x := range squashRates(rates) {
+		if x < 0.2-1e-8 || x > 0.6+1e-8 {
+			t.Fatalf("rate out of bounds: %f", x)
+		}
+		sum += x
+	}
+	if mean := sum / float64(len(rates)); math.Abs(mean-0.4) > 0.01 {
+		t.Errorf("expected mean 0.4 but got %f", mean)
+	}
+}
+
+func TestLogUniformRates(t *testing.T) {
+	rates := make(linalg.Vector, 10000)
+	(&LogUniformRates{MinHalfLife: 1, MaxHalfLife: 100}).InitRates(rates)
+	var belowTen int
+	for _, x := range squashRates(rates) {
+		halfLife := math.Log(0.5) / math.Log(1-x)
+		if halfLife < 1-1e-8 || halfLife > 100+1e-8 {
+			t.Fatalf("half-life out of bounds: %f", halfLife)
+		}
+		if halfLife < 10 {
+			belowTen++
+		}
+	}
+	if frac := float64(belowTen) / float64(len(rates)); math.Abs(frac-0.5) > 0.02 {
+		t.Errorf("expected half of the half-lives below 10, but got %f", frac)
+	}
+}
+
+func TestHalfLifeRates(t *testing.T) {
+	halfLives := []float64{1, 5, 100}
+	rates := make(linalg.Vector, 9)
+	(&HalfLifeRates{HalfLives: halfLives}).InitRates(rates)
+	counts := map[int]int{}
+	for _, x := range squashRates(rates) {
+		halfLife := math.Log(0.5) / math.Log(1-x)
+		counts[int(math.Floor(halfLife+0.5))]++
+	}
+	for _, h := range halfLives {
+		if counts[int(h)] != 3 {
+			t.Errorf("half-life %f: expected 3 rates but got %d", h, counts[int(h)])
+		}
+	}
+	expectPanic(t, "no half-lives", func() {
+		(&HalfLifeRates{}).InitRates(rates)
+	})
+}
+
+func squashRates(rates linalg.Vector) linalg.Vector {
+	res := make(linalg.Vector, len(rates))
+	for i, x := range rates {
+		res[i] = 1 / (1 + math.Exp(-x))
+	}
+	return res
+}
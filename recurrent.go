@@ -0,0 +1,156 @@
+package hebbnet
+
+import (
+	"encoding/json"
+
+	"github.com/unixpickle/autofunc"
+	"github.com/unixpickle/num-analysis/linalg"
+	"github.com/unixpickle/serializer"
+	"github.com/unixpickle/weakai/rnn"
+)
+
+func init() {
+	var r RecurrentDenseLayer
+	serializer.RegisterTypedDeserializer(r.SerializerType(), DeserializeRecurrentDenseLayer)
+}
+
+// A RecurrentDenseLayer is a fully-connected recurrent
+// layer which feeds its previous output back in as an
+// input, as in the RNN from the original paper.
+//
+// Both the input and the recurrent connections have fixed
+// weights as well as Hebbian plasticities.
+// The state of the layer is the Hebbian trace followed by
+// the previous output.
+type RecurrentDenseLayer struct {
+	// Dense is the underlying layer.
+	// Its inputs are the inputs to the recurrent layer,
+	// followed by the previous output of the layer.
+	// Thus, Dense.InputCount is the sum of the input and
+	// output counts of the recurrent layer.
+	Dense *DenseLayer
+
+	// InitHidden is the output fed back into the layer at
+	// the first timestep.
+	InitHidden *autofunc.Variable
+}
+
+// DeserializeRecurrentDenseLayer deserializes a
+// RecurrentDenseLayer.
+func DeserializeRecurrentDenseLayer(d []byte) (*RecurrentDenseLayer, error) {
+	var res RecurrentDenseLayer
+	if err := json.Unmarshal(d, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// NewRecurrentDenseLayer creates a RecurrentDenseLayer
+// with pre-initialized (semi-randomized) parameters.
+// The variableRate argument is passed to NewDenseLayer.
+//
+// The layer uses hyperbolic tangent activations, since
+// unsquashed outputs could explode through the recurrent
+// connections.
+func NewRecurrentDenseLayer(inCount, outCount int, variableRate bool) *RecurrentDenseLayer {
+	dense := NewDenseLayer(inCount+outCount, outCount, variableRate)
+	dense.UseActivation = true
+	return &RecurrentDenseLayer{
+		Dense:      dense,
+		InitHidden: &autofunc.Variable{Vector: make(linalg.Vector, outCount)},
+	}
+}
+
+// InputCount returns the number of inputs to the layer,
+// not including the recurrent inputs.
+func (r *RecurrentDenseLayer) InputCount() int {
+	return r.Dense.InputCount - r.Dense.OutputCount
+}
+
+// OutputCount returns the number of outputs from the
+// layer.
+func (r *RecurrentDenseLayer) OutputCount() int {
+	return r.Dense.OutputCount
+}
+
+// Parameters returns the layer's learnable parameters.
+func (r *RecurrentDenseLayer) Parameters() []*autofunc.Variable {
+	return append(r.Dense.Parameters(), r.InitHidden)
+}
+
+// StartState returns the initial trace and output.
+func (r *RecurrentDenseLayer) StartState() rnn.State {
+	return startVecState(r.startVars())
+}
+
+// StartRState returns the initial trace and output.
+func (r *RecurrentDenseLayer) StartRState(rv autofunc.RVector) rnn.RState {
+	return startVecRState(rv, r.startVars())
+}
+
+// PropagateStart propagates through the start state.
+func (r *RecurrentDenseLayer) PropagateStart(_ []rnn.State, s []rnn.StateGrad,
+	g autofunc.Gradient) {
+	propagateStartVars(r.startVars(), s, g)
+}
+
+// PropagateStartR propagates through the start state.
+func (r *RecurrentDenseLayer) PropagateStartR(_ []rnn.RState, s []rnn.RStateGrad,
+	rg autofunc.RGradient, g autofunc.Gradient) {
+	propagateStartVarsR(r.startVars(), s, rg, g)
+}
+
+// ApplyBlock applies the layer to a batch of inputs.
+func (r *RecurrentDenseLayer) ApplyBlock(s []rnn.State, in []autofunc.Result) rnn.BlockResult {
+	return applyBlock(s, in, r.timestep)
+}
+
+// ApplyBlockR applies the layer to a batch of inputs.
+func (r *RecurrentDenseLayer) ApplyBlockR(rv autofunc.RVector, s []rnn.RState,
+	in []autofunc.RResult) rnn.BlockRResult {
+	return applyBlockR(s, in, func(state, in autofunc.RResult) (newState, out autofunc.RResult) {
+		return r.timestepR(rv, state, in)
+	})
+}
+
+// SerializerType returns the unique ID used to serialize
+// this type with the serializer package.
+func (r *RecurrentDenseLayer) SerializerType() string {
+	return "github.com/unixpickle/hebbnet.RecurrentDenseLayer"
+}
+
+// Serialize serializes the layer.
+func (r *RecurrentDenseLayer) Serialize() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+func (r *RecurrentDenseLayer) startVars() []*autofunc.Variable {
+	return append(r.Dense.startVars(), r.InitHidden)
+}
+
+func (r *RecurrentDenseLayer) timestep(state, in autofunc.Result) (newState,
+	out autofunc.Result) {
+	denseSize := r.Dense.stateSize()
+	denseState := autofunc.Slice(state, 0, denseSize)
+	hidden := autofunc.Slice(state, denseSize, len(state.Output()))
+	fullIn := autofunc.Concat(in, hidden)
+
+	out, hebbOut := r.Dense.output(denseState, fullIn)
+	newDense := r.Dense.updateTrace(denseState, hebbOut, fullIn, r.Dense.traceRates())
+	newState = autofunc.Concat(newDense, out)
+	return
+}
+
+func (r *RecurrentDenseLayer) timestepR(rv autofunc.RVector, state,
+	in autofunc.RResult) (newState, out autofunc.RResult) {
+	denseSize := r.Dense.stateSize()
+	denseState := autofunc.SliceR(state, 0, denseSize)
+	hidden := autofunc.SliceR(state, denseSize, len(state.Output()))
+	fullIn := autofunc.ConcatR(in, hidden)
+
+	out, hebbOut := r.Dense.outputR(rv, denseState, fullIn)
+	newDense := r.Dense.updateTraceR(rv, denseState, hebbOut, fullIn,
+		r.Dense.traceRatesR(rv))
+	newState = autofunc.ConcatR(newDense, out)
+	return
+}
@@ -0,0 +1,13 @@
+package hebbnet
+
+import "testing"
+
+func TestRecurrentDense(t *testing.T) {
+	checkBlock(t, NewRecurrentDenseLayer(4, 2, true))
+}
+
+func TestRecurrentCovariance(t *testing.T) {
+	block := NewRecurrentDenseLayer(4, 2, true)
+	block.Dense.UseCovarianceRule(true)
+	checkBlock(t, block)
+}
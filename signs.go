@@ -0,0 +1,275 @@
+package hebbnet
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/unixpickle/autofunc"
+	"github.com/unixpickle/num-analysis/linalg"
+)
+
+// An InputSign constrains the signs of the weights and
+// plasticities of a DenseLayer's connections from one
+// input.
+type InputSign int
+
+const (
+	// Unconstrained connections may have any sign.
+	Unconstrained InputSign = iota
+
+	// Excitatory connections are always positive.
+	Excitatory
+
+	// Inhibitory connections are always negative.
+	Inhibitory
+)
+
+// minSignedMagnitude is the smallest magnitude which
+// ConstrainSigns gives to a constrained parameter.
+// Smaller magnitudes would leave the parameter with a
+// vanishing gradient.
+const minSignedMagnitude = 0.05
+
+// ConstrainSigns constrains the weights and plasticities
+// of every input's connections to have the given sign, in
+// the spirit of Dale's law.
+// There must be one sign per input.
+//
+// Existing weights and plasticities (in every trace bank)
+// are reparameterized so that their effective values keep
+// their magnitudes but take on the sign of their input.
+// Magnitudes smaller than a small threshold are rounded
+// up to the threshold.
+//
+// ConstrainSigns panics if the number of signs does not
+// match InputCount or if the layer already has sign
+// constraints, since reparameterizing the weights twice
+// would corrupt them.
+func (d *DenseLayer) ConstrainSigns(signs []InputSign) {
+	if len(signs) != d.InputCount {
+		panic(fmt.Sprintf("ConstrainSigns: got %d signs for %d inputs", len(signs),
+			d.InputCount))
+	}
+	if d.InputSigns != nil {
+		panic("ConstrainSigns: layer already has sign constraints")
+	}
+	d.InputSigns = append([]InputSign{}, signs...)
+	weightSigns := d.weightSigns()
+	d.Weights.Vector = unsoftplusSigned(d.Weights.Vector, weightSigns)
+	plasticSigns := d.plasticSigns()
+	for _, b := range d.TraceBanks() {
+		b.Plasticities.Vector = unsoftplusSigned(b.Plasticities.Vector, plasticSigns)
+	}
+}
+
+// EffectiveWeights returns the weight matrix which the
+// layer applies to its inputs.
+// For layers with sign constraints, this differs from
+// Weights, which stores unconstrained parameters.
+// Otherwise, the result aliases Weights.
+func (d *DenseLayer) EffectiveWeights() linalg.Vector {
+	return d.weights().Output()
+}
+
+// EffectivePlasticities is like EffectiveWeights, but for
+// the plasticities of the trace bank with the given index
+// in TraceBanks.
+func (d *DenseLayer) EffectivePlasticities(bank int) linalg.Vector {
+	return d.bankPlasticities(d.TraceBanks()[bank]).Output()
+}
+
+// weights returns the effective weight matrix.
+func (d *DenseLayer) weights() autofunc.Result {
+	if d.InputSigns == nil {
+		return d.Weights
+	}
+	return newSignedResult(d.Weights, d.weightSigns())
+}
+
+func (d *DenseLayer) weightsR(rv autofunc.RVector) autofunc.RResult {
+	if d.InputSigns == nil {
+		return autofunc.NewRVariable(d.Weights, rv)
+	}
+	return newSignedRResult(autofunc.NewRVariable(d.Weights, rv), d.weightSigns())
+}
+
+// bankPlasticities returns the effective plasticities of
+// a trace bank.
+func (d *DenseLayer) bankPlasticities(b *TraceBank) autofunc.Result {
+	if d.InputSigns == nil {
+		return b.Plasticities
+	}
+	return newSignedResult(b.Plasticities, d.plasticSigns())
+}
+
+func (d *DenseLayer) bankPlasticitiesR(rv autofunc.RVector, b *TraceBank) autofunc.RResult {
+	if d.InputSigns == nil {
+		return autofunc.NewRVariable(b.Plasticities, rv)
+	}
+	return newSignedRResult(autofunc.NewRVariable(b.Plasticities, rv), d.plasticSigns())
+}
+
+// weightSigns returns the sign constraint of every entry
+// of Weights.
+func (d *DenseLayer) weightSigns() []InputSign {
+	res := make([]InputSign, d.InputCount*d.OutputCount)
+	for i := range res {
+		res[i] = d.InputSigns[i%d.InputCount]
+	}
+	return res
+}
+
+// plasticSigns returns the sign constraint of every
+// plastic connection.
+func (d *DenseLayer) plasticSigns() []InputSign {
+	var res []InputSign
+	for i, plastic := range d.PlasticMask() {
+		if plastic {
+			res = append(res, d.InputSigns[i%d.InputCount])
+		}
+	}
+	return res
+}
+
+// signedResult applies sign constraints to a vector of
+// unconstrained parameters.
+// Constrained entries x become s*log(1+exp(x)), where s
+// is 1 for Excitatory and -1 for Inhibitory entries.
+type signedResult struct {
+	Input  autofunc.Result
+	Signs  []InputSign
+	Result linalg.Vector
+}
+
+func newSignedResult(in autofunc.Result, signs []InputSign) *signedResult {
+	return &signedResult{
+		Input:  in,
+		Signs:  signs,
+		Result: softplusSigned(in.Output(), signs),
+	}
+}
+
+func (s *signedResult) Output() linalg.Vector {
+	return s.Result
+}
+
+func (s *signedResult) Constant(g autofunc.Gradient) bool {
+	return s.Input.Constant(g)
+}
+
+func (s *signedResult) PropagateGradient(upstream linalg.Vector, g autofunc.Gradient) {
+	if s.Input.Constant(g) {
+		return
+	}
+	for i, x := range s.Input.Output() {
+		upstream[i] *= signedDeriv(x, s.Signs[i])
+	}
+	s.Input.PropagateGradient(upstream, g)
+}
+
+type signedRResult struct {
+	Input   autofunc.RResult
+	Signs   []InputSign
+	Result  linalg.Vector
+	RResult linalg.Vector
+}
+
+func newSignedRResult(in autofunc.RResult, signs []InputSign) *signedRResult {
+	res := &signedRResult{
+		Input:   in,
+		Signs:   signs,
+		Result:  softplusSigned(in.Output(), signs),
+		RResult: make(linalg.Vector, len(signs)),
+	}
+	for i, x := range in.Output() {
+		res.RResult[i] = signedDeriv(x, signs[i]) * in.ROutput()[i]
+	}
+	return res
+}
+
+func (s *signedRResult) Output() linalg.Vector {
+	return s.Result
+}
+
+func (s *signedRResult) ROutput() linalg.Vector {
+	return s.RResult
+}
+
+func (s *signedRResult) Constant(rg autofunc.RGradient, g autofunc.Gradient) bool {
+	return s.Input.Constant(rg, g)
+}
+
+func (s *signedRResult) PropagateRGradient(upstream, upstreamR linalg.Vector,
+	rg autofunc.RGradient, g autofunc.Gradient) {
+	if s.Input.Constant(rg, g) {
+		return
+	}
+	rIn := s.Input.ROutput()
+	for i, x := range s.Input.Output() {
+		deriv := signedDeriv(x, s.Signs[i])
+		upstreamR[i] = upstreamR[i]*deriv + upstream[i]*signedDeriv2(x, s.Signs[i])*rIn[i]
+		upstream[i] *= deriv
+	}
+	s.Input.PropagateRGradient(upstream, upstreamR, rg, g)
+}
+
+func softplusSigned(v linalg.Vector, signs []InputSign) linalg.Vector {
+	res := make(linalg.Vector, len(v))
+	for i, x := range v {
+		switch signs[i] {
+		case Excitatory:
+			res[i] = softplus(x)
+		case Inhibitory:
+			res[i] = -softplus(x)
+		default:
+			res[i] = x
+		}
+	}
+	return res
+}
+
+// unsoftplusSigned is the inverse of softplusSigned,
+// except that the signs of constrained entries are
+// ignored and small magnitudes are rounded up to
+// minSignedMagnitude.
+func unsoftplusSigned(v linalg.Vector, signs []InputSign) linalg.Vector {
+	res := make(linalg.Vector, len(v))
+	for i, x := range v {
+		if signs[i] == Unconstrained {
+			res[i] = x
+			continue
+		}
+		mag := math.Max(math.Abs(x), minSignedMagnitude)
+		res[i] = mag + math.Log(-math.Expm1(-mag))
+	}
+	return res
+}
+
+func softplus(x float64) float64 {
+	if x > 0 {
+		return x + math.Log1p(math.Exp(-x))
+	}
+	return math.Log1p(math.Exp(x))
+}
+
+func signedDeriv(x float64, sign InputSign) float64 {
+	switch sign {
+	case Excitatory:
+		return 1 / (1 + math.Exp(-x))
+	case Inhibitory:
+		return -1 / (1 + math.Exp(-x))
+	default:
+		return 1
+	}
+}
+
+func signedDeriv2(x float64, sign InputSign) float64 {
+	if sign == Unconstrained {
+		return 0
+	}
+	s := 1 / (1 + math.Exp(-x))
+	if sign == Inhibitory {
+		return -s * (1 - s)
+	}
+	return s * (1 - s)
+}
@@ -0,0 +1,112 @@
+package hebbnet
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/unixpickle/autofunc"
+	"github.com/unixpickle/num-analysis/linalg"
+	"github.com/unixpickle/weakai/rnn"
+)
+
+// stateSnapshot is the file format used by ExportState
+// and ImportState.
+type stateSnapshot struct {
+	Layers []linalg.Vector
+}
+
+// ExportState writes the state of a stacked model, such
+// as the Hebbian traces of its layers, to a file.
+// Every layer's state must be an rnn.VecState.
+func ExportState(path string, state rnn.StackedState) error {
+	var snapshot stateSnapshot
+	for i, s := range state {
+		vec, ok := s.(rnn.VecState)
+		if !ok {
+			return fmt.Errorf("layer %d: unsupported state type %T", i, s)
+		}
+		snapshot.Layers = append(snapshot.Layers, linalg.Vector(vec))
+	}
+	data, err := json.Marshal(&snapshot)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// ImportState reads a state written by ExportState.
+// It returns an error if the state does not fit the
+// layers of the given block.
+func ImportState(path string, block rnn.StackedBlock) (rnn.StackedState, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var snapshot stateSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, err
+	}
+	if len(snapshot.Layers) != len(block) {
+		return nil, fmt.Errorf("state has %d layers but block has %d", len(snapshot.Layers),
+			len(block))
+	}
+	res := make(rnn.StackedState, len(block))
+	for i, layer := range block {
+		start, ok := layer.StartState().(rnn.VecState)
+		if !ok {
+			return nil, fmt.Errorf("layer %d: unsupported state type %T", i, layer.StartState())
+		}
+		if len(start) != len(snapshot.Layers[i]) {
+			return nil, fmt.Errorf("layer %d: state has length %d (expected %d)", i,
+				len(snapshot.Layers[i]), len(start))
+		}
+		res[i] = rnn.VecState(snapshot.Layers[i])
+	}
+	return res, nil
+}
+
+// A StateRunner is like an rnn.Runner, but its state is
+// exposed so that it can be saved and restored.
+type StateRunner struct {
+	Block rnn.StackedBlock
+
+	// State is the current state of the block.
+	// If it is nil, the block's start state is used.
+	State rnn.StackedState
+}
+
+// RestoreRunner creates a StateRunner which starts from a
+// state saved by ExportState.
+func RestoreRunner(path string, block rnn.StackedBlock) (*StateRunner, error) {
+	state, err := ImportState(path, block)
+	if err != nil {
+		return nil, err
+	}
+	return &StateRunner{Block: block, State: state}, nil
+}
+
+// Reset goes back to the block's start state.
+func (s *StateRunner) Reset() {
+	s.State = nil
+}
+
+// StepTime evaluates the block on an input and updates
+// the state.
+func (s *StateRunner) StepTime(in linalg.Vector) linalg.Vector {
+	if s.State == nil {
+		s.State = s.Block.StartState().(rnn.StackedState)
+	}
+	res := s.Block.ApplyBlock([]rnn.State{s.State},
+		[]autofunc.Result{&autofunc.Variable{Vector: in}})
+	s.State = res.States()[0].(rnn.StackedState)
+	return res.Outputs()[0]
+}
+
+// Export saves the current state with ExportState.
+func (s *StateRunner) Export(path string) error {
+	if s.State == nil {
+		s.State = s.Block.StartState().(rnn.StackedState)
+	}
+	return ExportState(path, s.State)
+}
@@ -0,0 +1,85 @@
+package hebbnet
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/unixpickle/num-analysis/linalg"
+	"github.com/unixpickle/weakai/neuralnet"
+	"github.com/unixpickle/weakai/rnn"
+)
+
+func TestStateRunnerRestore(t *testing.T) {
+	block := snapshotTestBlock()
+	dir, err := ioutil.TempDir("", "hebbnet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+
+	var inputs []linalg.Vector
+	for i := 0; i < 10; i++ {
+		inputs = append(inputs, linalg.Vector{rand.NormFloat64(), rand.NormFloat64()})
+	}
+
+	expected := &rnn.Runner{Block: block}
+	runner := &StateRunner{Block: block}
+	for _, in := range inputs[:5] {
+		expected.StepTime(in)
+		runner.StepTime(in)
+	}
+	if err := runner.Export(path); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := RestoreRunner(path, block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, in := range inputs[5:] {
+		exp := expected.StepTime(in)
+		act := restored.StepTime(in)
+		if vecDiff(exp, act) > 1e-8 {
+			t.Errorf("step %d: expected %v but got %v", i+5, exp, act)
+		}
+	}
+}
+
+func TestImportStateMismatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hebbnet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+
+	block := snapshotTestBlock()
+	state := block.StartState().(rnn.StackedState)
+	state[0] = rnn.VecState(make(linalg.Vector, 3))
+	if err := ExportState(path, state); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ImportState(path, block); err == nil {
+		t.Error("expected error for mismatched state")
+	}
+	if _, err := ImportState(path, block[:2]); err == nil {
+		t.Error("expected error for wrong layer count")
+	}
+}
+
+func snapshotTestBlock() rnn.StackedBlock {
+	outNet := neuralnet.Network{
+		&neuralnet.DenseLayer{InputCount: 3, OutputCount: 2},
+		&neuralnet.LogSoftmaxLayer{},
+	}
+	outNet.Randomize()
+	layer1 := NewDenseLayer(2, 3, true)
+	layer1.UseActivation = true
+	layer2 := NewDenseLayer(3, 3, false)
+	layer2.UseCovarianceRule(true)
+	return rnn.StackedBlock{layer1, layer2, rnn.NewNetworkBlock(outNet, 0)}
+}
@@ -0,0 +1,78 @@
+package hebbnet
+
+import (
+	"github.com/unixpickle/autofunc"
+	"github.com/unixpickle/num-analysis/linalg"
+	"github.com/unixpickle/weakai/rnn"
+)
+
+// joinVecs concatenates vectors into a new vector.
+func joinVecs(vecs ...linalg.Vector) linalg.Vector {
+	var res linalg.Vector
+	for _, v := range vecs {
+		res = append(res, v...)
+	}
+	return res
+}
+
+// splitGrad splits up a gradient for the concatenation of
+// some variables and adds each piece to the gradient.
+func splitGrad(vars []*autofunc.Variable, upstream linalg.Vector, g autofunc.Gradient) {
+	for _, v := range vars {
+		if gVec, ok := g[v]; ok {
+			for i, x := range upstream[:len(v.Vector)] {
+				gVec[i] += x
+			}
+		}
+		upstream = upstream[len(v.Vector):]
+	}
+}
+
+// startVecState joins the values of variables into a
+// start state.
+func startVecState(vars []*autofunc.Variable) rnn.State {
+	if len(vars) == 1 {
+		return rnn.VecState(vars[0].Vector)
+	}
+	var vecs []linalg.Vector
+	for _, v := range vars {
+		vecs = append(vecs, v.Vector)
+	}
+	return rnn.VecState(joinVecs(vecs...))
+}
+
+// startVecRState is like startVecState for RStates.
+func startVecRState(rv autofunc.RVector, vars []*autofunc.Variable) rnn.RState {
+	var vecs, rVecs []linalg.Vector
+	for _, v := range vars {
+		rVar := autofunc.NewRVariable(v, rv)
+		vecs = append(vecs, rVar.Output())
+		rVecs = append(rVecs, rVar.ROutput())
+	}
+	return rnn.VecRState{State: joinVecs(vecs...), RState: joinVecs(rVecs...)}
+}
+
+// propagateStartVars propagates gradients through a start
+// state created by startVecState.
+func propagateStartVars(vars []*autofunc.Variable, s []rnn.StateGrad, g autofunc.Gradient) {
+	for _, x := range s {
+		if x != nil {
+			splitGrad(vars, linalg.Vector(x.(rnn.VecStateGrad)), g)
+		}
+	}
+}
+
+// propagateStartVarsR is like propagateStartVars for
+// RStateGrads.
+func propagateStartVarsR(vars []*autofunc.Variable, s []rnn.RStateGrad,
+	rg autofunc.RGradient, g autofunc.Gradient) {
+	for _, x := range s {
+		if x != nil {
+			vecGrad := x.(rnn.VecRStateGrad)
+			if g != nil {
+				splitGrad(vars, vecGrad.State, g)
+			}
+			splitGrad(vars, vecGrad.RState, autofunc.Gradient(rg))
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package hebbnet
+
+import (
+	"github.com/unixpickle/autofunc"
+	"github.com/unixpickle/num-analysis/linalg"
+)
+
+// UseSTDPRule switches the layer to STDPRule and creates
+// its timing coefficients.
+//
+// The coefficients start out at 1 and -1, so that, as in
+// spike-timing-dependent plasticity, an input followed by
+// an output strengthens a connection and an output
+// followed by an input weakens it.
+func (d *DenseLayer) UseSTDPRule() {
+	d.Rule = STDPRule
+	d.TimingCoeffs = &autofunc.Variable{Vector: []float64{1, -1}}
+}
+
+// initHistory creates a constant for the initial rule
+// state of STDPRule, in which the previous output and
+// input are both zero.
+func (d *DenseLayer) initHistory() *autofunc.Variable {
+	return &autofunc.Variable{Vector: make(linalg.Vector, d.OutputCount+d.InputCount)}
+}
+
+// timingProduct computes the full matrix accumulated by
+// STDPRule, given the history (the previous output
+// followed by the previous input).
+func (d *DenseLayer) timingProduct(out, in, history autofunc.Result) autofunc.Result {
+	outCount := d.OutputCount
+	prevOut := autofunc.Slice(history, 0, outCount)
+	prevIn := autofunc.Slice(history, outCount, outCount+d.InputCount)
+	forward := autofunc.ScaleFirst(autofunc.OuterProduct(out, prevIn),
+		autofunc.Slice(d.TimingCoeffs, 0, 1))
+	backward := autofunc.ScaleFirst(autofunc.OuterProduct(prevOut, in),
+		autofunc.Slice(d.TimingCoeffs, 1, 2))
+	return autofunc.Add(autofunc.OuterProduct(out, in), autofunc.Add(forward, backward))
+}
+
+func (d *DenseLayer) timingProductR(rv autofunc.RVector, out, in,
+	history autofunc.RResult) autofunc.RResult {
+	outCount := d.OutputCount
+	coeffs := autofunc.NewRVariable(d.TimingCoeffs, rv)
+	prevOut := autofunc.SliceR(history, 0, outCount)
+	prevIn := autofunc.SliceR(history, outCount, outCount+d.InputCount)
+	forward := autofunc.ScaleFirstR(autofunc.OuterProductR(out, prevIn),
+		autofunc.SliceR(coeffs, 0, 1))
+	backward := autofunc.ScaleFirstR(autofunc.OuterProductR(prevOut, in),
+		autofunc.SliceR(coeffs, 1, 2))
+	return autofunc.AddR(autofunc.OuterProductR(out, in), autofunc.AddR(forward, backward))
+}
@@ -0,0 +1,190 @@
+package hebbnet
+
+import (
+	"github.com/unixpickle/autofunc"
+	"github.com/unixpickle/num-analysis/linalg"
+	"github.com/unixpickle/sgd"
+	"github.com/unixpickle/weakai/neuralnet"
+	"github.com/unixpickle/weakai/rnn"
+	"github.com/unixpickle/weakai/rnn/seqtoseq"
+)
+
+// TruncatedBPTT is an sgd.Gradienter which uses truncated
+// back-propagation through time.
+// It can be used in place of seqtoseq.BPTT, and it expects
+// the same kind of samples (seqtoseq.Sample).
+//
+// Sequences are processed in chunks of Truncation steps.
+// The states, including any Hebbian traces, are carried
+// forward through entire sequences.
+// After each chunk (and at the end of the sequences), the
+// costs from the chunk are propagated back through the
+// chunk and the Truncation timesteps before it in a single
+// pass.
+// Thus, every cost is propagated back through at least
+// Truncation timesteps (unless it is closer than that to
+// the start of its sequence), each timestep is
+// back-propagated at most twice, and at most two chunks'
+// worth of autofunc graphs are kept in memory at once.
+//
+// Chunks whose cost gradients are all zero, such as ones
+// with all-zero expected outputs under neuralnet.DotCost,
+// are not back-propagated at all.
+type TruncatedBPTT struct {
+	Block    rnn.Block
+	Learner  sgd.Learner
+	CostFunc neuralnet.CostFunc
+
+	// Truncation is the number of timesteps in each chunk.
+	// If it is 0, gradients are propagated through entire
+	// sequences, as in seqtoseq.BPTT.
+	Truncation int
+}
+
+// Gradient computes the gradient of the total cost of
+// the samples with respect to the learner's parameters.
+func (t *TruncatedBPTT) Gradient(s sgd.SampleSet) autofunc.Gradient {
+	grad := autofunc.NewGradient(t.Learner.Parameters())
+
+	var samples []seqtoseq.Sample
+	var maxLen int
+	for i := 0; i < s.Len(); i++ {
+		sample := s.GetSample(i).(seqtoseq.Sample)
+		samples = append(samples, sample)
+		if len(sample.Inputs) > maxLen {
+			maxLen = len(sample.Inputs)
+		}
+	}
+
+	chunkSize := t.Truncation
+	if chunkSize == 0 {
+		chunkSize = maxLen
+	}
+
+	startStates := make([]rnn.State, len(samples))
+	for i := range startStates {
+		startStates[i] = t.Block.StartState()
+	}
+	states := append([]rnn.State{}, startStates...)
+
+	// window stores the current chunk, preceded by up to
+	// chunkSize earlier timesteps.
+	var window []*tbpttStep
+	for chunkStart := 0; chunkStart < maxLen; chunkStart += chunkSize {
+		for step := chunkStart; step < chunkStart+chunkSize && step < maxLen; step++ {
+			window = append(window, t.applyStep(samples, states, step))
+		}
+		t.propagateWindow(samples, startStates, window, chunkStart, grad)
+		if len(window) > chunkSize {
+			window = append([]*tbpttStep{}, window[len(window)-chunkSize:]...)
+		}
+	}
+
+	return grad
+}
+
+// applyStep applies the block to every sample which is
+// active at the given timestep, updating states.
+func (t *TruncatedBPTT) applyStep(samples []seqtoseq.Sample, states []rnn.State,
+	step int) *tbpttStep {
+	res := &tbpttStep{Index: step}
+	var stepStates []rnn.State
+	var stepInputs []autofunc.Result
+	for i, sample := range samples {
+		if step < len(sample.Inputs) {
+			res.Lanes = append(res.Lanes, i)
+			stepStates = append(stepStates, states[i])
+			stepInputs = append(stepInputs, &autofunc.Variable{Vector: sample.Inputs[step]})
+		}
+	}
+	res.Result = t.Block.ApplyBlock(stepStates, stepInputs)
+	for j, lane := range res.Lanes {
+		states[lane] = res.Result.States()[j]
+	}
+	return res
+}
+
+// propagateWindow back-propagates through a window of
+// consecutive timesteps, accumulating gradients into grad.
+// Only the costs at timesteps from costStart onward are
+// propagated.
+func (t *TruncatedBPTT) propagateWindow(samples []seqtoseq.Sample, startStates []rnn.State,
+	window []*tbpttStep, costStart int, grad autofunc.Gradient) {
+	if len(window) == 0 {
+		return
+	}
+
+	upstreams := make([][]linalg.Vector, len(window))
+	var nonZero bool
+	for j, step := range window {
+		if step.Index >= costStart {
+			upstreams[j] = t.costGradients(samples, step)
+			nonZero = nonZero || anyNonZero(upstreams[j])
+		} else {
+			for _, out := range step.Result.Outputs() {
+				upstreams[j] = append(upstreams[j], make(linalg.Vector, len(out)))
+			}
+		}
+	}
+	if !nonZero {
+		return
+	}
+
+	// laneGrads stores the upstream state gradient for
+	// each sample, or nil if there is none.
+	laneGrads := make([]rnn.StateGrad, len(samples))
+	for j := len(window) - 1; j >= 0; j-- {
+		step := window[j]
+		var stateUpstream []rnn.StateGrad
+		for _, lane := range step.Lanes {
+			stateUpstream = append(stateUpstream, laneGrads[lane])
+		}
+		downstream := step.Result.PropagateGradient(upstreams[j], stateUpstream, grad)
+		for k, lane := range step.Lanes {
+			laneGrads[lane] = downstream[k]
+		}
+	}
+
+	if window[0].Index == 0 {
+		t.Block.PropagateStart(startStates, laneGrads, grad)
+	}
+}
+
+// costGradients computes the gradient of the cost with
+// respect to each of a timestep's outputs.
+func (t *TruncatedBPTT) costGradients(samples []seqtoseq.Sample,
+	step *tbpttStep) []linalg.Vector {
+	var res []linalg.Vector
+	for k, lane := range step.Lanes {
+		expected := samples[lane].Outputs[step.Index]
+		actual := step.Result.Outputs()[k]
+		res = append(res, costGradient(t.CostFunc, expected, actual))
+	}
+	return res
+}
+
+// tbpttStep stores the result of applying a block to
+// every sequence which is active at a given timestep.
+type tbpttStep struct {
+	Index  int
+	Lanes  []int
+	Result rnn.BlockResult
+}
+
+// costGradient computes the gradient of a cost function
+// with respect to the actual output.
+func costGradient(c neuralnet.CostFunc, expected, actual linalg.Vector) linalg.Vector {
+	v := &autofunc.Variable{Vector: actual}
+	g := autofunc.NewGradient([]*autofunc.Variable{v})
+	c.Cost(expected, v).PropagateGradient(linalg.Vector{1}, g)
+	return g[v]
+}
+
+func anyNonZero(vecs []linalg.Vector) bool {
+	for _, v := range vecs {
+		if v.MaxAbs() != 0 {
+			return true
+		}
+	}
+	return false
+}
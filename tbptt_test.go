@@ -0,0 +1,209 @@
+package hebbnet
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/unixpickle/autofunc"
+	"github.com/unixpickle/num-analysis/linalg"
+	"github.com/unixpickle/sgd"
+	"github.com/unixpickle/weakai/neuralnet"
+	"github.com/unixpickle/weakai/rnn"
+	"github.com/unixpickle/weakai/rnn/seqtoseq"
+)
+
+func TestTruncatedBPTTFull(t *testing.T) {
+	block := tbpttTestBlock()
+	samples := tbpttTestSamples([]int{5, 3, 7})
+	expected := (&seqtoseq.BPTT{
+		Block:    block,
+		Learner:  block,
+		CostFunc: &neuralnet.DotCost{},
+	}).Gradient(samples)
+	for _, truncation := range []int{0, 7, 10} {
+		actual := (&TruncatedBPTT{
+			Block:      block,
+			Learner:    block,
+			CostFunc:   &neuralnet.DotCost{},
+			Truncation: truncation,
+		}).Gradient(samples)
+		for _, param := range block.Parameters() {
+			if vecDiff(expected[param], actual[param]) > 1e-6 {
+				t.Errorf("truncation %d: expected %v but got %v", truncation,
+					expected[param], actual[param])
+			}
+		}
+	}
+}
+
+func TestTruncatedBPTTChunks(t *testing.T) {
+	block := tbpttTestBlock()
+	samples := tbpttTestSamples([]int{5, 3, 7})
+	for _, truncation := range []int{1, 2, 3} {
+		expected := chunkedGradient(block, samples, truncation)
+		actual := (&TruncatedBPTT{
+			Block:      block,
+			Learner:    block,
+			CostFunc:   &neuralnet.DotCost{},
+			Truncation: truncation,
+		}).Gradient(samples)
+		for _, param := range block.Parameters() {
+			if vecDiff(expected[param], actual[param]) > 1e-6 {
+				t.Errorf("truncation %d: expected %v but got %v", truncation,
+					expected[param], actual[param])
+			}
+		}
+	}
+}
+
+func TestTruncatedBPTTFinalCost(t *testing.T) {
+	block := tbpttTestBlock()
+	layer := block[0].(*DenseLayer)
+
+	// Only the last output has a cost, like in the MNIST
+	// experiment, so it should be propagated through the
+	// chunk before the final (partial) chunk.
+	samples := tbpttTestSamples([]int{7})
+	sample := samples.GetSample(0).(seqtoseq.Sample)
+	for _, out := range sample.Outputs[:len(sample.Outputs)-1] {
+		for i := range out {
+			out[i] = 0
+		}
+	}
+
+	// With 3 steps per chunk, the last cost is propagated
+	// through timesteps 3-6; with 4, through timesteps 0-6.
+	reachesStart := map[int]bool{3: false, 4: true}
+	for truncation, reaches := range reachesStart {
+		grad := (&TruncatedBPTT{
+			Block:      block,
+			Learner:    block,
+			CostFunc:   &neuralnet.DotCost{},
+			Truncation: truncation,
+		}).Gradient(samples)
+		initGrad := grad[layer.InitTrace].MaxAbs()
+		if !reaches && initGrad != 0 {
+			t.Errorf("truncation %d: unexpected initial trace gradient: %v", truncation,
+				grad[layer.InitTrace])
+		} else if reaches && initGrad == 0 {
+			t.Errorf("truncation %d: expected non-zero initial trace gradient", truncation)
+		}
+		expected := chunkedGradient(block, samples, truncation)
+		for _, param := range block.Parameters() {
+			if vecDiff(expected[param], grad[param]) > 1e-6 {
+				t.Errorf("truncation %d: expected %v but got %v", truncation,
+					expected[param], grad[param])
+			}
+		}
+	}
+}
+
+func TestTruncatedBPTTEmpty(t *testing.T) {
+	block := tbpttTestBlock()
+	sampleSets := []sgd.SampleSet{sgd.SliceSampleSet{}, tbpttTestSamples([]int{0, 0})}
+	for _, samples := range sampleSets {
+		for _, truncation := range []int{0, 2} {
+			grad := (&TruncatedBPTT{
+				Block:      block,
+				Learner:    block,
+				CostFunc:   &neuralnet.DotCost{},
+				Truncation: truncation,
+			}).Gradient(samples)
+			for _, param := range block.Parameters() {
+				if grad[param].MaxAbs() != 0 {
+					t.Errorf("truncation %d: unexpected gradient %v", truncation, grad[param])
+				}
+			}
+		}
+	}
+}
+
+// chunkedGradient computes a truncated gradient by
+// running seqtoseq.BPTT separately on each chunk of a
+// sequence, preceded by up to truncation earlier
+// timesteps.
+// It assumes neuralnet.DotCost, so that all-zero outputs
+// have no cost.
+func chunkedGradient(block rnn.StackedBlock, samples sgd.SampleSet,
+	truncation int) autofunc.Gradient {
+	grad := autofunc.NewGradient(block.Parameters())
+	for i := 0; i < samples.Len(); i++ {
+		sample := samples.GetSample(i).(seqtoseq.Sample)
+		states := []rnn.State{block.StartState()}
+		for _, in := range sample.Inputs {
+			res := block.ApplyBlock(states[len(states)-1:],
+				[]autofunc.Result{&autofunc.Variable{Vector: in}})
+			states = append(states, res.States()[0])
+		}
+		for chunkStart := 0; chunkStart < len(sample.Inputs); chunkStart += truncation {
+			var windowBlock rnn.Block = block
+			start := chunkStart - truncation
+			if start > 0 {
+				windowBlock = &fixedStartBlock{Block: block, Start: states[start]}
+			} else {
+				start = 0
+			}
+			var window seqtoseq.Sample
+			for j := start; j < chunkStart+truncation && j < len(sample.Inputs); j++ {
+				window.Inputs = append(window.Inputs, sample.Inputs[j])
+				if j >= chunkStart {
+					window.Outputs = append(window.Outputs, sample.Outputs[j])
+				} else {
+					window.Outputs = append(window.Outputs,
+						make(linalg.Vector, len(sample.Outputs[j])))
+				}
+			}
+			grad.Add((&seqtoseq.BPTT{
+				Block:    windowBlock,
+				Learner:  block,
+				CostFunc: &neuralnet.DotCost{},
+			}).Gradient(sgd.SliceSampleSet{window}))
+		}
+	}
+	return grad
+}
+
+// fixedStartBlock is an rnn.Block which starts from a
+// constant state that has no gradient.
+type fixedStartBlock struct {
+	rnn.Block
+	Start rnn.State
+}
+
+func (f *fixedStartBlock) StartState() rnn.State {
+	return f.Start
+}
+
+func (f *fixedStartBlock) PropagateStart(s []rnn.State, u []rnn.StateGrad,
+	g autofunc.Gradient) {
+}
+
+func tbpttTestBlock() rnn.StackedBlock {
+	outNet := neuralnet.Network{
+		&neuralnet.DenseLayer{InputCount: 3, OutputCount: 2},
+		&neuralnet.LogSoftmaxLayer{},
+	}
+	outNet.Randomize()
+	layer := NewDenseLayer(2, 3, true)
+	layer.UseActivation = true
+	for i := range layer.InitTrace.Vector {
+		layer.InitTrace.Vector[i] = rand.NormFloat64()
+		layer.Plasticities.Vector[i] = rand.NormFloat64()
+	}
+	return rnn.StackedBlock{layer, rnn.NewNetworkBlock(outNet, 0)}
+}
+
+func tbpttTestSamples(lengths []int) sgd.SampleSet {
+	var res sgd.SliceSampleSet
+	for _, length := range lengths {
+		var sample seqtoseq.Sample
+		for i := 0; i < length; i++ {
+			sample.Inputs = append(sample.Inputs,
+				linalg.Vector{rand.NormFloat64(), rand.NormFloat64()})
+			sample.Outputs = append(sample.Outputs,
+				linalg.Vector{rand.Float64(), rand.Float64()})
+		}
+		res = append(res, sample)
+	}
+	return res
+}
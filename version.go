@@ -0,0 +1,243 @@
+package hebbnet
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/unixpickle/autofunc"
+	"github.com/unixpickle/weakai/neuralnet"
+)
+
+// denseLayerVersion is the current version of the JSON
+// format used to serialize DenseLayers.
+//
+// Version 0 refers to unversioned layers, written before
+// the format had a Version field.
+// Version 1 added the Version field and stores tanh layers
+// using Activation rather than UseActivation.
+//
+// When adding a field whose zero value would change the
+// behavior of layers saved in an older version, bump the
+// version and add a migration to denseLayerMigrations.
+const denseLayerVersion = 1
+
+// denseLayerMigrations contains, for every version v
+// before denseLayerVersion, a function which upgrades a
+// layer decoded from version v to version v+1.
+var denseLayerMigrations = []func(d *DenseLayer) error{
+	migrateDenseLayerV0,
+}
+
+// denseLayerJSON is the JSON representation of a
+// DenseLayer.
+type denseLayerJSON struct {
+	*rawDenseLayer
+
+	Version int
+
+	// Activation shadows DenseLayer.Activation, storing it
+	// in serialized form.
+	Activation []byte `json:",omitempty"`
+}
+
+// rawDenseLayer is a DenseLayer without its JSON methods.
+type rawDenseLayer DenseLayer
+
+// MarshalJSON encodes the layer as JSON, serializing the
+// activation function with the serializer package.
+func (d *DenseLayer) MarshalJSON() ([]byte, error) {
+	obj := denseLayerJSON{rawDenseLayer: (*rawDenseLayer)(d), Version: denseLayerVersion}
+	var err error
+	obj.Activation, err = serializeActivation(d.Activation)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&obj)
+}
+
+// UnmarshalJSON decodes the layer from JSON.
+//
+// Layers saved with older versions of the format are
+// migrated to the current version.
+// An error is returned if the decoded layer is invalid,
+// for example if its parameter vectors do not have the
+// lengths implied by InputCount and OutputCount.
+func (d *DenseLayer) UnmarshalJSON(data []byte) error {
+	obj, err := d.decodeJSON(data)
+	if err != nil {
+		return err
+	}
+	return d.finishDecode(obj)
+}
+
+// decodeJSON decodes the JSON representation of a layer
+// into d without migrating or validating it.
+func (d *DenseLayer) decodeJSON(data []byte) (*denseLayerJSON, error) {
+	obj := &denseLayerJSON{rawDenseLayer: (*rawDenseLayer)(d)}
+	if err := json.Unmarshal(data, obj); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// finishDecode deserializes the activation of a decoded
+// layer, migrates the layer to the current version, and
+// validates it.
+func (d *DenseLayer) finishDecode(obj *denseLayerJSON) error {
+	if obj.Version < 0 || obj.Version > denseLayerVersion {
+		return fmt.Errorf("unsupported DenseLayer version %d (latest is %d)", obj.Version,
+			denseLayerVersion)
+	}
+	var err error
+	d.Activation, err = deserializeActivation(obj.Activation)
+	if err != nil {
+		return err
+	}
+	for _, migrate := range denseLayerMigrations[obj.Version:] {
+		if err := migrate(d); err != nil {
+			return err
+		}
+	}
+	return d.validate()
+}
+
+// migrateDenseLayerV0 replaces the legacy UseActivation
+// flag with an explicit tanh activation.
+func migrateDenseLayerV0(d *DenseLayer) error {
+	if d.UseActivation && d.Activation == nil {
+		d.Activation = &neuralnet.HyperbolicTangent{}
+	}
+	d.UseActivation = false
+	return nil
+}
+
+// validate checks that the layer's fields are consistent
+// with each other, returning a descriptive error if they
+// are not.
+func (d *DenseLayer) validate() error {
+	if d.InputCount <= 0 || d.OutputCount <= 0 {
+		return fmt.Errorf("invalid DenseLayer dimensions: %d inputs, %d outputs",
+			d.InputCount, d.OutputCount)
+	}
+	weightCount := d.InputCount * d.OutputCount
+	plasticCount := weightCount
+	if d.PlasticIndices != nil {
+		plasticCount = len(d.PlasticIndices)
+		for i, idx := range d.PlasticIndices {
+			if idx < 0 || idx >= weightCount {
+				return fmt.Errorf("DenseLayer plastic index %d out of range [0, %d)", idx,
+					weightCount)
+			} else if i > 0 && idx <= d.PlasticIndices[i-1] {
+				return errors.New("DenseLayer plastic indices must be strictly ascending")
+			}
+		}
+	}
+
+	var rateCounts []int
+	switch d.RateGranularity {
+	case PerWeightRates:
+		rateCounts = []int{1, plasticCount}
+	case PerRowRates:
+		rateCounts = []int{d.OutputCount}
+	case PerRowColumnRates:
+		rateCounts = []int{d.OutputCount + d.InputCount}
+	default:
+		return fmt.Errorf("unknown DenseLayer rate granularity: %d", d.RateGranularity)
+	}
+
+	// The other rates must be laid out exactly like
+	// TraceRate, since SetPlasticMask and DenseStepper
+	// assume as much.
+	otherRateCounts := rateCounts
+	if d.TraceRate != nil {
+		otherRateCounts = []int{len(d.TraceRate.Vector)}
+	}
+
+	checks := []lengthCheck{
+		{"Weights", d.Weights, false, []int{weightCount}},
+		{"Biases", d.Biases, false, []int{d.OutputCount}},
+		{"Plasticities", d.Plasticities, false, []int{plasticCount}},
+		{"InitTrace", d.InitTrace, false, []int{plasticCount}},
+		{"TraceRate", d.TraceRate, false, rateCounts},
+		{"DecayRate", d.DecayRate, true, otherRateCounts},
+	}
+	for i, b := range d.Banks {
+		if b == nil {
+			return fmt.Errorf("DenseLayer is missing Banks[%d]", i)
+		}
+		checks = append(checks,
+			lengthCheck{fmt.Sprintf("Banks[%d].TraceRate", i), b.TraceRate, false,
+				otherRateCounts},
+			lengthCheck{fmt.Sprintf("Banks[%d].Plasticities", i), b.Plasticities, false,
+				[]int{plasticCount}},
+			lengthCheck{fmt.Sprintf("Banks[%d].InitTrace", i), b.InitTrace, false,
+				[]int{plasticCount}})
+	}
+	switch d.Rule {
+	case HebbRule, OjaRule:
+	case CovarianceRule:
+		thresholdCount := d.OutputCount + d.InputCount
+		checks = append(checks,
+			lengthCheck{"Thresholds", d.Thresholds, false, []int{thresholdCount}},
+			lengthCheck{"ThresholdRate", d.ThresholdRate, true, []int{1, thresholdCount}})
+	case STDPRule:
+		checks = append(checks, lengthCheck{"TimingCoeffs", d.TimingCoeffs, false, []int{2}})
+	default:
+		return fmt.Errorf("unknown DenseLayer plasticity rule: %d", d.Rule)
+	}
+
+	for _, check := range checks {
+		if err := check.Check(); err != nil {
+			return err
+		}
+	}
+
+	if d.InputSigns != nil {
+		if len(d.InputSigns) != d.InputCount {
+			return fmt.Errorf("DenseLayer InputSigns has length %d (expected %d)",
+				len(d.InputSigns), d.InputCount)
+		}
+		for _, sign := range d.InputSigns {
+			if sign < Unconstrained || sign > Inhibitory {
+				return fmt.Errorf("unknown DenseLayer input sign: %d", sign)
+			}
+		}
+	}
+
+	if d.TraceClip < 0 || d.TraceRowNorm < 0 {
+		return errors.New("DenseLayer trace bounds must not be negative")
+	}
+	return nil
+}
+
+// A lengthCheck verifies that a DenseLayer parameter has
+// one of a set of allowed lengths.
+type lengthCheck struct {
+	Name     string
+	Var      *autofunc.Variable
+	Optional bool
+	Lengths  []int
+}
+
+// Check returns an error if the variable is missing or
+// has the wrong length.
+func (l lengthCheck) Check() error {
+	if l.Var == nil {
+		if l.Optional {
+			return nil
+		}
+		return fmt.Errorf("DenseLayer is missing %s", l.Name)
+	}
+	for _, length := range l.Lengths {
+		if len(l.Var.Vector) == length {
+			return nil
+		}
+	}
+	expected := fmt.Sprint(l.Lengths[0])
+	if len(l.Lengths) > 1 {
+		expected = fmt.Sprintf("%d or %d", l.Lengths[0], l.Lengths[1])
+	}
+	return fmt.Errorf("DenseLayer %s has length %d (expected %s)", l.Name,
+		len(l.Var.Vector), expected)
+}
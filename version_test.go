@@ -0,0 +1,149 @@
+package hebbnet
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/unixpickle/num-analysis/linalg"
+	"github.com/unixpickle/weakai/neuralnet"
+)
+
+func TestDenseGoldenV0(t *testing.T) {
+	layer := loadGoldenDense(t, "dense_v0.json")
+	if _, ok := layer.Activation.(*neuralnet.HyperbolicTangent); !ok || layer.UseActivation {
+		t.Errorf("UseActivation was not migrated (got %T)", layer.Activation)
+	}
+	if layer.RateGranularity != PerWeightRates || layer.Rule != HebbRule ||
+		layer.DecayRate != nil || layer.PlasticIndices != nil {
+		t.Error("unexpected defaults for new fields")
+	}
+	checkVec(t, "Weights", layer.Weights.Vector, []float64{0.1, 0.2, 0.3, -0.1, -0.2, -0.3})
+	checkVec(t, "TraceRate", layer.TraceRate.Vector, []float64{0.5, -0.5, 1, -1, 2, -2})
+}
+
+func TestDenseGoldenV1(t *testing.T) {
+	layer := loadGoldenDense(t, "dense_v1.json")
+	if layer.Activation != nil {
+		t.Errorf("unexpected activation: %T", layer.Activation)
+	}
+	if layer.RateGranularity != PerRowRates || layer.Rule != CovarianceRule ||
+		layer.TraceClip != 0.5 {
+		t.Error("unexpected layer settings")
+	}
+	if len(layer.PlasticIndices) != 2 || layer.PlasticIndices[1] != 3 {
+		t.Errorf("unexpected plastic indices: %v", layer.PlasticIndices)
+	}
+	checkVec(t, "DecayRate", layer.DecayRate.Vector, []float64{-0.25, 0.75})
+	checkVec(t, "Thresholds", layer.Thresholds.Vector, []float64{0, 0.1, 0.2, 0.3})
+	checkVec(t, "InitTrace", layer.InitTrace.Vector, []float64{0.01, 0.02})
+}
+
+func TestDenseVersionRoundTrip(t *testing.T) {
+	layer := NewDenseLayerRates(3, 2, PerRowColumnRates)
+	layer.UseCovarianceRule(true)
+	data, err := layer.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var obj struct {
+		Version int
+	}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		t.Fatal(err)
+	}
+	if obj.Version != denseLayerVersion {
+		t.Errorf("expected version %d but got %d", denseLayerVersion, obj.Version)
+	}
+	decoded, err := DeserializeDenseLayer(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkVec(t, "Weights", decoded.Weights.Vector, layer.Weights.Vector)
+	checkVec(t, "Thresholds", decoded.Thresholds.Vector, layer.Thresholds.Vector)
+}
+
+func TestDenseValidation(t *testing.T) {
+	cases := map[string]func(d *DenseLayer){
+		"Weights has length 5": func(d *DenseLayer) {
+			d.Weights.Vector = d.Weights.Vector[:5]
+		},
+		"Biases has length 3": func(d *DenseLayer) {
+			d.Biases.Vector = append(d.Biases.Vector, 0)
+		},
+		"InitTrace has length 6 (expected 2)": func(d *DenseLayer) {
+			d.PlasticIndices = []int{0, 1}
+			d.Plasticities.Vector = d.Plasticities.Vector[:2]
+		},
+		"TraceRate has length 2 (expected 1 or 6)": func(d *DenseLayer) {
+			d.TraceRate.Vector = make(linalg.Vector, 2)
+		},
+		"DecayRate has length 6 (expected 1)": func(d *DenseLayer) {
+			d.UntieDecay()
+			d.DecayRate.Vector = make(linalg.Vector, 6)
+		},
+		"missing Plasticities": func(d *DenseLayer) {
+			d.Plasticities = nil
+		},
+		"plastic index 6 out of range": func(d *DenseLayer) {
+			d.SetPlasticMask(BlockDiagonalMask(3, 2, 1))
+			d.PlasticIndices[len(d.PlasticIndices)-1] = 6
+		},
+		"missing Thresholds": func(d *DenseLayer) {
+			d.Rule = CovarianceRule
+		},
+		"TimingCoeffs has length 1 (expected 2)": func(d *DenseLayer) {
+			d.UseSTDPRule()
+			d.TimingCoeffs.Vector = d.TimingCoeffs.Vector[:1]
+		},
+		"InputSigns has length 2 (expected 3)": func(d *DenseLayer) {
+			d.InputSigns = []InputSign{Excitatory, Inhibitory}
+		},
+		"unsupported DenseLayer version 2": func(d *DenseLayer) {
+		},
+	}
+	for expected, mutate := range cases {
+		layer := NewDenseLayer(3, 2, false)
+		mutate(layer)
+		data, err := json.Marshal(layer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(expected, "version") {
+			data = []byte(strings.Replace(string(data), `"Version":1`, `"Version":2`, 1))
+		}
+		_, err = DeserializeDenseLayer(data)
+		if err == nil {
+			t.Errorf("expected error containing %q", expected)
+		} else if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected error containing %q but got %q", expected, err)
+		}
+	}
+}
+
+func loadGoldenDense(t *testing.T, name string) *DenseLayer {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	layer, err := DeserializeDenseLayer(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return layer
+}
+
+func checkVec(t *testing.T, name string, actual, expected linalg.Vector) {
+	if len(actual) != len(expected) {
+		t.Errorf("%s: expected %v but got %v", name, expected, actual)
+		return
+	}
+	for i, x := range expected {
+		if actual[i] != x {
+			t.Errorf("%s: expected %v but got %v", name, expected, actual)
+			return
+		}
+	}
+}